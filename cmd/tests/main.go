@@ -2,15 +2,19 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/jawr/mos6502/cpu"
 	mos6502 "github.com/jawr/mos6502/cpu"
-	term "github.com/nsf/termbox-go"
 )
 
 func main() {
@@ -19,12 +23,19 @@ func main() {
 	stop := flag.Uint("stop", 0, "Stop address")
 	debug := flag.Bool("debug", false, "Output each step")
 	trapDetector := flag.Bool("trapDetector", false, "Detect traps and stop")
+	startMonitor := flag.Bool("monitor", false, "Start in the monitor")
 
 	flag.Parse()
 
 	memory, err := loadROM(*rom)
 	if err != nil {
 		log.Printf("error loading ROM: %s", err)
+		switch {
+		case errors.Is(err, cpu.ErrROMTooLarge):
+			log.Printf("the ROM has to fit in 64k")
+		case errors.Is(err, cpu.ErrBadRecord):
+			log.Printf("check the file extension matches its format")
+		}
 		os.Exit(1)
 	}
 
@@ -41,62 +52,83 @@ func main() {
 
 	// setup interrupt
 	q := make(chan os.Signal, 1)
-	signal.Notify(q, os.Interrupt)
 
 	log.Printf("Starting CPU...")
 
-	// used for stepping through cpu
-	step := false
+	// the monitor is entered on ctrl-c in debug mode or at a breakpoint
+	mon := newMonitor(cpu, memory, os.Stdout)
+	input := bufio.NewScanner(os.Stdin)
+	monitoring := false
+
+	// the prompt blocks reading input so ctrl-c is left to quit there, while
+	// running it is caught to stop or drop in to the monitor
+	setMonitoring := func(on bool) {
+		monitoring = on
+		if on {
+			signal.Reset(os.Interrupt)
+		} else {
+			signal.Notify(q, os.Interrupt)
+		}
+	}
+	setMonitoring(*startMonitor)
+
+	// continuing from a breakpoint should not stop on it straight away
+	resuming := false
 
 	// run cpu
 MainLoop:
 	for {
-		if step {
-			ev := term.PollEvent()
-			if ev.Type != term.EventKey {
-				log.Printf("event: %v", ev)
-				os.Exit(1)
+		if monitoring {
+			fmt.Print("> ")
+			if !input.Scan() {
+				break MainLoop
+			}
+
+			cmd, err := parseCommand(input.Text())
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			if cmd.action == actionQuit {
+				break MainLoop
+			}
+
+			if mon.run(cmd) {
+				setMonitoring(false)
+				resuming = true
 			}
 
-			switch ev.Key {
-			case term.KeyEnter:
-				break
-			case term.KeyCtrlC:
-				term.Close()
+			if cpu.Halt() != mos6502.Continue {
 				break MainLoop
 			}
+
+			continue
 		}
 
 		select {
 		case <-q:
 			log.Printf("CTRL-C pressed...")
-			// if first ctrl c and debug drop in to step mode
-			if !step && *debug {
-				log.Printf("Entering step mode...")
-
-				// setup term
-				err = term.Init()
-				if err != nil {
-					log.Printf("error initializing termbox: %s", err)
-					os.Exit(1)
-				}
-
-				step = true
-
+			// if debug drop in to the monitor
+			if *debug {
+				log.Printf("Entering monitor, h for help...")
+				setMonitoring(true)
 				continue MainLoop
 			}
 			break MainLoop
 		default:
+			if !resuming && mon.atBreakpoint() {
+				log.Printf("Breakpoint at %04x, h for help...", cpu.PC())
+				setMonitoring(true)
+				continue MainLoop
+			}
+			resuming = false
 
-			// if we are in step mode we should wait for enter key to
-			// be pressed before continuing
-
-			cpu.Cycle()
+			cpu.Step()
 
 			if cpu.Halt() != mos6502.Continue {
 				break MainLoop
 			}
-
 		}
 	}
 
@@ -115,6 +147,14 @@ MainLoop:
 		log.Printf("CPU halted on trap")
 	case mos6502.HaltUnknownInstruction:
 		log.Printf("CPU halted on unknown instruction")
+	case mos6502.HaltStackGuard:
+		log.Printf("CPU halted on stack guard")
+	case mos6502.HaltWaitingForInterrupt:
+		log.Printf("CPU halted waiting for an interrupt")
+	case mos6502.HaltStopped:
+		log.Printf("CPU halted on STP")
+	case mos6502.HaltExecutingVectors:
+		log.Printf("CPU halted executing the vectors")
 	}
 
 	if cpu.Halt() != mos6502.HaltSuccess {
@@ -125,37 +165,205 @@ MainLoop:
 }
 
 func loadROM(path string) (*cpu.Memory, error) {
-	// open rom
-	file, err := os.Open(path)
+	// records place themselves, anything else is a raw image from $0000
+	var load func(io.Reader, *cpu.Memory) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hex", ".ihx":
+		load = cpu.LoadHex
+	case ".s19", ".s28", ".s37", ".srec":
+		load = cpu.LoadSRec
+	}
+	if load != nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		memory := cpu.NewMemory()
+		if err := load(file, memory); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		log.Printf("Loaded ROM: %s", path)
+
+		return memory, nil
+	}
+
+	rom, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	stats, err := file.Stat()
+	memory, err := cpu.NewMemoryWithROM(rom, 0x0000)
 	if err != nil {
 		return nil, err
 	}
 
-	memory := &cpu.Memory{}
+	log.Printf("Loaded ROM: %s (%d)", path, len(rom))
+
+	return memory, nil
+}
+
+// what a monitor command does
+type action uint8
+
+const (
+	actionStep action = iota
+	actionContinue
+	actionBreakpoint
+	actionMemory
+	actionRegisters
+	actionDisassemble
+	actionQuit
+	actionHelp
+)
+
+// command parsed from a line of monitor input
+type command struct {
+	action  action
+	address uint16
+}
+
+const monitorHelp = `s        step one instruction
+c        continue until a breakpoint or halt
+b $addr  toggle a breakpoint
+m $addr  dump 16 bytes of memory
+r        show registers
+d        disassemble the next instruction
+q        quit
+`
+
+// parse a line of monitor input, an empty line steps
+func parseCommand(input string) (command, error) {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return command{action: actionStep}, nil
+	}
+
+	var cmd command
+
+	switch fields[0] {
+	case "s":
+		cmd.action = actionStep
+	case "c":
+		cmd.action = actionContinue
+	case "b":
+		cmd.action = actionBreakpoint
+	case "m":
+		cmd.action = actionMemory
+	case "r":
+		cmd.action = actionRegisters
+	case "d":
+		cmd.action = actionDisassemble
+	case "q":
+		cmd.action = actionQuit
+	case "h", "?":
+		cmd.action = actionHelp
+	default:
+		return cmd, fmt.Errorf("unknown command %q", fields[0])
+	}
+
+	// breakpoints and memory dumps need an address
+	needsAddress := cmd.action == actionBreakpoint || cmd.action == actionMemory
+
+	switch {
+	case needsAddress && len(fields) != 2:
+		return cmd, fmt.Errorf("%s needs an address", fields[0])
+	case !needsAddress && len(fields) != 1:
+		return cmd, fmt.Errorf("%s takes no arguments", fields[0])
+	}
 
-	if stats.Size() > int64(len(memory)) {
-		return nil, fmt.Errorf("ROM too large. Wanted %d got %d", len(memory), stats.Size())
+	if needsAddress {
+		address, err := parseAddress(fields[1])
+		if err != nil {
+			return cmd, err
+		}
+		cmd.address = address
 	}
 
-	buff := make([]byte, stats.Size())
-	reader := bufio.NewReader(file)
+	return cmd, nil
+}
+
+// addresses are hex with an optional $ or 0x prefix
+func parseAddress(s string) (uint16, error) {
+	s = strings.TrimPrefix(s, "$")
+	s = strings.TrimPrefix(s, "0x")
 
-	_, err = reader.Read(buff)
+	address, err := strconv.ParseUint(s, 16, 16)
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("invalid address %q", s)
 	}
 
-	for i, ch := range buff {
-		memory[i] = ch
+	return uint16(address), nil
+}
+
+// monitor drives the cpu from commands and tracks breakpoints
+type monitor struct {
+	cpu         *mos6502.MOS6502
+	memory      *mos6502.Memory
+	out         io.Writer
+	breakpoints map[uint16]bool
+}
+
+func newMonitor(cpu *mos6502.MOS6502, memory *mos6502.Memory, out io.Writer) *monitor {
+	return &monitor{
+		cpu:         cpu,
+		memory:      memory,
+		out:         out,
+		breakpoints: make(map[uint16]bool),
 	}
+}
 
-	log.Printf("Loaded ROM: %s (%d)", path, stats.Size())
+// true if the cpu is about to execute an instruction with a breakpoint
+func (m *monitor) atBreakpoint() bool {
+	return m.breakpoints[m.cpu.PC()]
+}
 
-	return memory, nil
+// run a command, returning true if the monitor should hand back control
+func (m *monitor) run(cmd command) bool {
+	switch cmd.action {
+	case actionStep:
+		disasm, err := m.cpu.StepTrace()
+		if disasm != nil {
+			fmt.Fprintf(m.out, "%04x  %s\n", disasm.Address, disasm.Disassembly)
+		}
+		if err != nil {
+			fmt.Fprintln(m.out, err)
+			return true
+		}
+		fmt.Fprintln(m.out, m.cpu.Registers())
+
+	case actionContinue, actionQuit:
+		return true
+
+	case actionBreakpoint:
+		if m.breakpoints[cmd.address] {
+			delete(m.breakpoints, cmd.address)
+			fmt.Fprintf(m.out, "breakpoint at %04x removed\n", cmd.address)
+		} else {
+			m.breakpoints[cmd.address] = true
+			fmt.Fprintf(m.out, "breakpoint at %04x set\n", cmd.address)
+		}
+
+	case actionMemory:
+		fmt.Fprintf(m.out, "%04x ", cmd.address)
+		for i := uint16(0); i < 16; i++ {
+			fmt.Fprintf(m.out, " %02x", m.memory.Read(cmd.address+i))
+		}
+		fmt.Fprintln(m.out)
+
+	case actionRegisters:
+		fmt.Fprintln(m.out, m.cpu.Registers())
+
+	case actionDisassemble:
+		pc := m.cpu.PC()
+		disasm := m.cpu.Disassemble(pc)
+		fmt.Fprintf(m.out, "%04x  %s\n", disasm.Address, disasm.Disassembly)
+
+	case actionHelp:
+		fmt.Fprint(m.out, monitorHelp)
+	}
+
+	return false
 }
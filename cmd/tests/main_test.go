@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect command
+	}{
+		{"", command{action: actionStep}},
+		{"s", command{action: actionStep}},
+		{"c", command{action: actionContinue}},
+		{"b $c000", command{action: actionBreakpoint, address: 0xc000}},
+		{"b 0x0400", command{action: actionBreakpoint, address: 0x0400}},
+		{"m 200", command{action: actionMemory, address: 0x0200}},
+		{"  r  ", command{action: actionRegisters}},
+		{"d", command{action: actionDisassemble}},
+		{"q", command{action: actionQuit}},
+		{"?", command{action: actionHelp}},
+	}
+
+	for _, tc := range tests {
+		cmd, err := parseCommand(tc.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tc.input, err)
+			continue
+		}
+		if cmd != tc.expect {
+			t.Errorf("%q: expected %+v got %+v", tc.input, tc.expect, cmd)
+		}
+	}
+}
+
+func TestParseCommandErrors(t *testing.T) {
+	for _, input := range []string{
+		"x",
+		"b",
+		"m",
+		"b $10000",
+		"m $zz",
+		"s 1",
+		"r $10",
+	} {
+		if _, err := parseCommand(input); err == nil {
+			t.Errorf("expected error parsing %q", input)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package cpu
+
+// count an access, the counters are only allocated once something is counted
+func (cpu *MOS6502) count(counters **[0x10000]uint64, address uint16) {
+	if *counters == nil {
+		*counters = &[0x10000]uint64{}
+	}
+	(*counters)[address]++
+}
+
+// AccessCounts returns how many times each address has been read and
+// written while CountAccesses was set. addresses never accessed are left
+// out
+func (cpu *MOS6502) AccessCounts() (reads, writes map[uint16]uint64) {
+	return counts(cpu.reads), counts(cpu.writes)
+}
+
+func counts(counters *[0x10000]uint64) map[uint16]uint64 {
+	m := make(map[uint16]uint64)
+	if counters == nil {
+		return m
+	}
+	for address, n := range counters {
+		if n > 0 {
+			m[uint16(address)] = n
+		}
+	}
+	return m
+}
+
+// InstructionHistogram returns how many times each instruction has been
+// executed while CountInstructions was set, combining its addressing modes
+func (cpu *MOS6502) InstructionHistogram() map[OPCode]uint64 {
+	m := make(map[OPCode]uint64, len(cpu.executed))
+	for opc, n := range cpu.executed {
+		m[opc] = n
+	}
+	return m
+}
@@ -0,0 +1,112 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestAccessCounts(t *testing.T) {
+	program, err := Assemble(`
+			LDA #$05
+			STA $10
+		loop:	DEC $10
+			BNE loop
+		done:	JMP done
+	`, ProgramStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := setup(program.Bytes, nil)
+	cpu.CountAccesses = true
+	cpu.TrapDetector = true
+	cpu.Quiet = true
+
+	for i := 0; i < 100 && cpu.Halt() == Continue; i++ {
+		cpu.Step()
+	}
+
+	reads, writes := cpu.AccessCounts()
+
+	// DEC reads then writes the counter on each of the 5 passes
+	if reads[0x10] != 5 {
+		t.Errorf("expected 5 reads of the counter got %d", reads[0x10])
+	}
+	if writes[0x10] != 6 {
+		t.Errorf("expected 6 writes of the counter got %d", writes[0x10])
+	}
+	// the DEC opcode is fetched once per pass
+	if reads[0xdd04] != 5 {
+		t.Errorf("expected 5 fetches of DEC got %d", reads[0xdd04])
+	}
+	if _, ok := writes[0x11]; ok {
+		t.Error("expected untouched addresses to be left out")
+	}
+
+	// nothing is counted when disabled
+	cpu = setup(program.Bytes, nil)
+	cpu.Step()
+	if reads, writes := cpu.AccessCounts(); len(reads) != 0 || len(writes) != 0 {
+		t.Errorf("expected no counts got %d reads and %d writes", len(reads), len(writes))
+	}
+}
+
+func TestInstructionHistogram(t *testing.T) {
+	program, err := Assemble(`
+			LDX #$10
+		loop:	LDA $1000,X
+			STA $2000,X
+			LDA $10
+			DEX
+			BNE loop
+		done:	JMP done
+	`, ProgramStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := setup(program.Bytes, nil)
+	cpu.CountInstructions = true
+	cpu.TrapDetector = true
+	cpu.Quiet = true
+
+	for i := 0; i < 200 && cpu.Halt() == Continue; i++ {
+		cpu.Step()
+	}
+
+	expected := map[OPCode]uint64{
+		OPC_LDX: 1,
+		// both addressing modes
+		OPC_LDA: 32,
+		OPC_STA: 16,
+		OPC_DEX: 16,
+		OPC_BNE: 16,
+		// the second time round is caught as a trap before executing
+		OPC_JMP: 1,
+	}
+	histogram := cpu.InstructionHistogram()
+	if len(histogram) != len(expected) {
+		t.Errorf("expected %d instructions got %v", len(expected), histogram)
+	}
+	for opc, n := range expected {
+		if histogram[opc] != n {
+			t.Errorf("expected %s %d times got %d", opc, n, histogram[opc])
+		}
+	}
+}
+
+func TestInstructionHistogramVariant(t *testing.T) {
+	// an extra instruction at $02 followed by a NOP
+	cpu := setup([]uint8{0x02, 0xea}, nil)
+	cpu.CountInstructions = true
+	cpu.RegisterInstruction(0x02, OPCode("EXT"), 2, 1, func(cpu *MOS6502, data uint16) {}, AM_IMPLIED)
+
+	cpu.Step()
+	cpu.Step()
+
+	// the counts outlive the instruction table they were made with
+	cpu.SetVariant(NMOS6502)
+	histogram := cpu.InstructionHistogram()
+	if histogram[OPCode("EXT")] != 1 || histogram[OPC_NOP] != 1 {
+		t.Errorf("expected EXT and NOP once each got %v", histogram)
+	}
+}
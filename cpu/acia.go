@@ -0,0 +1,156 @@
+package cpu
+
+import (
+	"io"
+)
+
+// 6551 ACIA registers, offsets from the base address
+const (
+	// reading takes the received byte, writing transmits one
+	ACIAData uint16 = iota
+	// receive full and transmit empty bits, writing does a programmed reset
+	ACIAStatus
+	ACIACommand
+	ACIAControl
+)
+
+// status bits
+const (
+	// a received byte is waiting in the data register
+	ACIAReceiveFull uint8 = 0x08
+	// the data register can take another byte to transmit
+	ACIATransmitEmpty uint8 = 0x10
+)
+
+// ACIA is a 6551 serial interface mapped at base. Bytes read from in are
+// received and bytes written to the data register are sent to out straight
+// away so transmit is always empty. Any address other than the four
+// registers is passed to the underlying bus
+type ACIA struct {
+	bus  Bus
+	base uint16
+	out  io.Writer
+
+	// filled by a goroutine reading in so the cpu never blocks
+	received chan uint8
+
+	// latest byte and if it has been read yet
+	data uint8
+	full bool
+
+	command uint8
+	control uint8
+}
+
+// NewACIA maps an ACIA at base, either of in or out can be nil
+func NewACIA(bus Bus, base uint16, in io.Reader, out io.Writer) *ACIA {
+	a := &ACIA{
+		bus:      bus,
+		base:     base,
+		out:      out,
+		received: make(chan uint8, 256),
+	}
+
+	if in != nil {
+		go a.receive(in)
+	}
+
+	return a
+}
+
+// feed bytes from in until it is exhausted
+func (a *ACIA) receive(in io.Reader) {
+	defer close(a.received)
+
+	buf := make([]byte, 1)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			a.received <- buf[0]
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// latch the next received byte if the last one has been read
+func (a *ACIA) poll() {
+	if a.full {
+		return
+	}
+
+	select {
+	case b, ok := <-a.received:
+		if ok {
+			a.data = b
+			a.full = true
+		}
+	default:
+	}
+}
+
+// register at address, false if it is not one of the ACIA's
+func (a *ACIA) register(address uint16) (uint16, bool) {
+	offset := address - a.base
+	return offset, address >= a.base && offset <= ACIAControl
+}
+
+func (a *ACIA) Read(address uint16) uint8 {
+	register, ok := a.register(address)
+	if !ok {
+		return a.bus.Read(address)
+	}
+
+	switch register {
+	case ACIAData:
+		a.poll()
+		a.full = false
+		return a.data
+
+	case ACIAStatus:
+		a.poll()
+		status := ACIATransmitEmpty
+		if a.full {
+			status |= ACIAReceiveFull
+		}
+		return status
+
+	case ACIACommand:
+		return a.command
+	}
+
+	return a.control
+}
+
+func (a *ACIA) Peek(address uint16) (uint8, bool) {
+	if _, ok := a.register(address); ok {
+		return 0, false
+	}
+	return peekBus(a.bus, address)
+}
+
+func (a *ACIA) Write(address uint16, value uint8) {
+	register, ok := a.register(address)
+	if !ok {
+		a.bus.Write(address, value)
+		return
+	}
+
+	switch register {
+	case ACIAData:
+		if a.out != nil {
+			a.out.Write([]byte{value})
+		}
+
+	case ACIAStatus:
+		// programmed reset clears the low command bits
+		a.command &^= 0x1f
+
+	case ACIACommand:
+		a.command = value
+
+	case ACIAControl:
+		a.control = value
+	}
+}
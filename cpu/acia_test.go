@@ -0,0 +1,61 @@
+package cpu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestACIA(t *testing.T) {
+	// echo everything received back out, upper casing it
+	program, err := Assemble(`
+		wait:	LDA $8001
+			AND #$08
+			BEQ wait
+			LDA $8000
+			AND #$df
+			STA $8000
+			JMP wait
+	`, ProgramStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := setup(program.Bytes, nil)
+	out := &bytes.Buffer{}
+	acia := NewACIA(cpu.memory, 0x8000, strings.NewReader("hi"), out)
+	cpu.SetBus(acia)
+
+	// the bytes arrive from another goroutine so poll for them
+	for i := 0; i < 10000000 && out.Len() < 2; i++ {
+		cpu.Step()
+	}
+
+	if out.String() != "HI" {
+		t.Errorf("expected HI got %q", out.String())
+	}
+}
+
+func TestACIARegisters(t *testing.T) {
+	memory := &Memory{}
+	acia := NewACIA(memory, 0x8000, nil, nil)
+
+	// nothing to receive but always ready to transmit
+	expect8(t, acia.Read(0x8001), newUint8(ACIATransmitEmpty))
+
+	acia.Write(0x8002, 0x0b)
+	acia.Write(0x8003, 0x1f)
+	expect8(t, acia.Read(0x8002), newUint8(0x0b))
+	expect8(t, acia.Read(0x8003), newUint8(0x1f))
+
+	// programmed reset clears the low command bits only
+	acia.Write(0x8001, 0x00)
+	expect8(t, acia.Read(0x8002), newUint8(0x00))
+	expect8(t, acia.Read(0x8003), newUint8(0x1f))
+
+	// outside the registers goes to memory
+	acia.Write(0x8004, 0x42)
+	acia.Write(0x7fff, 0x43)
+	expect8(t, memory[0x8004], newUint8(0x42))
+	expect8(t, acia.Read(0x7fff), newUint8(0x43))
+}
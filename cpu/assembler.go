@@ -0,0 +1,521 @@
+package cpu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Program is the output of the assembler
+type Program struct {
+	// address of the first byte, the lowest address anything was
+	// assembled to
+	Origin uint16
+	// assembled bytes, any gaps left by .org are zero
+	Bytes []uint8
+	// label addresses, usable as the disassembler's symbol table
+	Symbols map[uint16]string
+
+	// a statement was assembled over the reset vector, rather than it
+	// being left as part of a gap
+	setsReset bool
+}
+
+// Load copies the program in to memory at its origin
+func (p *Program) Load(memory *Memory) {
+	for i, b := range p.Bytes {
+		memory[p.Origin+uint16(i)] = b
+	}
+}
+
+// NewFromAssembly assembles src at org in to fresh memory and returns a
+// reset cpu ready to run it. the reset vector points at org unless the
+// program sets it itself
+func NewFromAssembly(src string, org uint16) (*MOS6502, error) {
+	program, err := Assemble(src, org)
+	if err != nil {
+		return nil, err
+	}
+
+	memory := NewMemory()
+	program.Load(memory)
+
+	if !program.setsReset {
+		memory[RESVectorLow], memory[RESVectorHigh] = Bytes(org)
+	}
+
+	cpu := NewMOS6502()
+	cpu.Reset(memory)
+	cpu.Symbols = program.Symbols
+
+	return cpu, nil
+}
+
+// a number or label, with an optional < or > to take the low or high byte
+type expression struct {
+	value uint16
+	label string
+	part  byte
+}
+
+// a parsed line of source, either an instruction or a data directive
+type statement struct {
+	line    int
+	address uint16
+	size    uint16
+
+	// instruction, empty for data
+	opc  OPCode
+	mode AddressMode
+
+	// operand of an instruction or the values of a .byte or .word
+	operands []*expression
+	// size of each data value
+	width uint16
+}
+
+type assembler struct {
+	// opcode lookup by instruction and mode
+	opcodes map[OPCode]map[AddressMode]uint8
+	labels  map[string]uint16
+	// current assembly address
+	address uint16
+}
+
+func newAssembler(origin uint16) *assembler {
+	a := &assembler{
+		opcodes: make(map[OPCode]map[AddressMode]uint8),
+		labels:  make(map[string]uint16),
+		address: origin,
+	}
+
+	// the WDC 65C02 has every documented NMOS instruction plus the CMOS
+	// ones, at the same opcodes
+	cpu := NewMOS6502()
+	cpu.SetVariant(WDC65C02)
+	for opcode, instruction := range cpu.instructions {
+		if instruction == nil || instruction.undocumented {
+			continue
+		}
+		modes, ok := a.opcodes[instruction.opc]
+		if !ok {
+			modes = make(map[AddressMode]uint8)
+			a.opcodes[instruction.opc] = modes
+		}
+		modes[instruction.mode] = uint8(opcode)
+	}
+
+	return a
+}
+
+// Assemble 6502 source in to a program that runs at origin. Each line can
+// hold a label ending in a colon, an instruction or directive and a comment
+// starting with a semicolon. Numbers can be written as $hex, %binary or
+// decimal. The 65C02 instructions are accepted too, it is up to the caller
+// to only use them on a cpu that has them. Supported directives are:
+//
+//	.org $c000       continue assembling at a new address
+//	.byte $01, 2     emit bytes
+//	.word start, $10 emit little endian words, i.e. vectors at $fffa
+func Assemble(src string, origin uint16) (*Program, error) {
+	a := newAssembler(origin)
+
+	// first pass works out where everything lives
+	var statements []*statement
+	for i, text := range strings.Split(src, "\n") {
+		stmt, err := a.parseLine(i+1, text)
+		if err != nil {
+			return nil, err
+		}
+		if stmt == nil {
+			continue
+		}
+		if int(stmt.address)+int(stmt.size) > 0x10000 {
+			return nil, fmt.Errorf("line %d: assembled past $FFFF", stmt.line)
+		}
+		statements = append(statements, stmt)
+		a.address += stmt.size
+	}
+
+	// second pass resolves labels and emits bytes
+	program := &Program{
+		Origin:  origin,
+		Symbols: make(map[uint16]string),
+	}
+
+	for label, address := range a.labels {
+		program.Symbols[address] = label
+	}
+
+	if len(statements) == 0 {
+		return program, nil
+	}
+
+	// the program spans from the lowest to the highest address used
+	start, end := int(statements[0].address), 0
+	for _, stmt := range statements {
+		if int(stmt.address) < start {
+			start = int(stmt.address)
+		}
+		if int(stmt.address)+int(stmt.size) > end {
+			end = int(stmt.address) + int(stmt.size)
+		}
+	}
+
+	program.Origin = uint16(start)
+	program.Bytes = make([]uint8, end-start)
+
+	for _, stmt := range statements {
+		b, err := a.emit(stmt)
+		if err != nil {
+			return nil, err
+		}
+		copy(program.Bytes[int(stmt.address)-start:], b)
+
+		end := int(stmt.address) + int(stmt.size)
+		if int(stmt.address) <= int(RESVectorHigh) && end > int(RESVectorLow) {
+			program.setsReset = true
+		}
+	}
+
+	return program, nil
+}
+
+// parse a single line returning nil if it emits nothing
+func (a *assembler) parseLine(line int, text string) (*statement, error) {
+	// strip comments
+	if i := strings.Index(text, ";"); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimSpace(text)
+
+	// labels
+	if i := strings.Index(text, ":"); i >= 0 {
+		label := strings.TrimSpace(text[:i])
+		if !validLabel(label) {
+			return nil, fmt.Errorf("line %d: invalid label %q", line, label)
+		}
+		if _, ok := a.labels[label]; ok {
+			return nil, fmt.Errorf("line %d: duplicate label %q", line, label)
+		}
+		a.labels[label] = a.address
+		text = strings.TrimSpace(text[i+1:])
+	}
+
+	if text == "" {
+		return nil, nil
+	}
+
+	// split the mnemonic or directive from its operand
+	name, operand := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		name = text[:i]
+		operand = strings.Join(strings.Fields(text[i:]), "")
+	}
+
+	if strings.HasPrefix(name, ".") {
+		stmt, err := a.parseDirective(line, strings.ToLower(name), operand)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		return stmt, nil
+	}
+
+	opc := OPCode(strings.ToUpper(name))
+	modes, ok := a.opcodes[opc]
+	if !ok {
+		return nil, fmt.Errorf("line %d: unknown instruction %q", line, name)
+	}
+
+	stmt := &statement{
+		line:    line,
+		address: a.address,
+		opc:     opc,
+	}
+
+	if err := a.parseOperand(stmt, operand, modes); err != nil {
+		return nil, fmt.Errorf("line %d: %s", line, err)
+	}
+
+	stmt.size = uint16(instructionSize(stmt.mode))
+
+	return stmt, nil
+}
+
+// handle .org, .byte and .word
+func (a *assembler) parseDirective(line int, directive, operand string) (*statement, error) {
+	switch directive {
+	case ".org":
+		address, err := parseNumber(operand)
+		if err != nil {
+			return nil, err
+		}
+		a.address = address
+		return nil, nil
+
+	case ".byte", ".word":
+		if operand == "" {
+			return nil, fmt.Errorf("%s needs at least one value", directive)
+		}
+
+		stmt := &statement{
+			line:    line,
+			address: a.address,
+			width:   1,
+		}
+		if directive == ".word" {
+			stmt.width = 2
+		}
+
+		for _, value := range strings.Split(operand, ",") {
+			expr, err := parseExpression(value)
+			if err != nil {
+				return nil, err
+			}
+			stmt.operands = append(stmt.operands, expr)
+		}
+
+		stmt.size = stmt.width * uint16(len(stmt.operands))
+
+		return stmt, nil
+	}
+
+	return nil, fmt.Errorf("unknown directive %q", directive)
+}
+
+// work out the addressing mode and value of the operand
+func (a *assembler) parseOperand(stmt *statement, operand string, modes map[AddressMode]uint8) error {
+	upper := strings.ToUpper(operand)
+
+	// candidate modes in order of preference, zeropage first
+	var candidates []AddressMode
+	value := operand
+
+	switch {
+	case operand == "":
+		candidates = []AddressMode{AM_IMPLIED, AM_ACCUMULATOR}
+
+	case upper == "A":
+		candidates = []AddressMode{AM_ACCUMULATOR}
+		value = ""
+
+	case strings.HasPrefix(operand, "#"):
+		candidates = []AddressMode{AM_IMMEDIATE}
+		value = operand[1:]
+
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(upper, ",X)"):
+		candidates = []AddressMode{AM_INDIRECT_X, AM_ABSOLUTE_X_INDIRECT}
+		value = operand[1 : len(operand)-3]
+
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(upper, "),Y"):
+		candidates = []AddressMode{AM_INDIRECT_Y}
+		value = operand[1 : len(operand)-3]
+
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(operand, ")"):
+		candidates = []AddressMode{AM_INDIRECT}
+		value = operand[1 : len(operand)-1]
+
+	case strings.HasSuffix(upper, ",X"):
+		candidates = []AddressMode{AM_ZEROPAGE_X, AM_ABSOLUTE_X}
+		value = operand[:len(operand)-2]
+
+	case strings.HasSuffix(upper, ",Y"):
+		candidates = []AddressMode{AM_ZEROPAGE_Y, AM_ABSOLUTE_Y}
+		value = operand[:len(operand)-2]
+
+	default:
+		candidates = []AddressMode{AM_RELATIVE, AM_ZEROPAGE, AM_ABSOLUTE}
+	}
+
+	expr := &expression{}
+	if value != "" {
+		var err error
+		expr, err = parseExpression(value)
+		if err != nil {
+			return err
+		}
+		stmt.operands = []*expression{expr}
+	}
+
+	for _, mode := range candidates {
+		if _, ok := modes[mode]; !ok {
+			continue
+		}
+		// labels could be anywhere so only literals use the zeropage
+		if isZeroPage(mode) && (expr.label != "" || expr.value > 0xff) {
+			continue
+		}
+		stmt.mode = mode
+		return nil
+	}
+
+	return fmt.Errorf("%s does not support operand %q", stmt.opc, operand)
+}
+
+// parse a number or label with an optional < or > to take the low or high byte
+func parseExpression(s string) (*expression, error) {
+	if s == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	expr := &expression{}
+
+	if s[0] == '<' || s[0] == '>' {
+		expr.part = s[0]
+		s = s[1:]
+	}
+
+	if validLabel(s) {
+		expr.label = s
+		return expr, nil
+	}
+
+	value, err := parseNumber(s)
+	if err != nil {
+		return nil, err
+	}
+	expr.value = expr.apply(value)
+
+	return expr, nil
+}
+
+// take the low or high byte if asked for
+func (e *expression) apply(value uint16) uint16 {
+	switch e.part {
+	case '<':
+		return value & 0xff
+	case '>':
+		return value >> 8
+	}
+	return value
+}
+
+// resolve the value of an expression, looking up any label
+func (a *assembler) resolve(stmt *statement, expr *expression) (uint16, error) {
+	if expr.label == "" {
+		return expr.value, nil
+	}
+
+	address, ok := a.labels[expr.label]
+	if !ok {
+		return 0, fmt.Errorf("line %d: unknown label %q", stmt.line, expr.label)
+	}
+
+	return expr.apply(address), nil
+}
+
+// resolve any labels and emit the bytes for a statement
+func (a *assembler) emit(stmt *statement) ([]uint8, error) {
+	if stmt.opc == "" {
+		return a.emitData(stmt)
+	}
+
+	var value uint16
+	if len(stmt.operands) > 0 {
+		var err error
+		value, err = a.resolve(stmt, stmt.operands[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b := []uint8{a.opcodes[stmt.opc][stmt.mode]}
+
+	switch stmt.size {
+	case 2:
+		if stmt.mode == AM_RELATIVE {
+			offset := int(value) - int(stmt.address+2)
+			if offset < -128 || offset > 127 {
+				return nil, fmt.Errorf("line %d: branch out of range (%d)", stmt.line, offset)
+			}
+			value = uint16(uint8(int8(offset)))
+		}
+		if value > 0xff {
+			return nil, fmt.Errorf("line %d: operand $%04X does not fit in a byte", stmt.line, value)
+		}
+		b = append(b, uint8(value))
+
+	case 3:
+		lo, hi := Bytes(value)
+		b = append(b, lo, hi)
+	}
+
+	return b, nil
+}
+
+// emit the values of a .byte or .word
+func (a *assembler) emitData(stmt *statement) ([]uint8, error) {
+	var b []uint8
+
+	for _, expr := range stmt.operands {
+		value, err := a.resolve(stmt, expr)
+		if err != nil {
+			return nil, err
+		}
+
+		if stmt.width == 1 {
+			if value > 0xff {
+				return nil, fmt.Errorf("line %d: value $%04X does not fit in a byte", stmt.line, value)
+			}
+			b = append(b, uint8(value))
+			continue
+		}
+
+		lo, hi := Bytes(value)
+		b = append(b, lo, hi)
+	}
+
+	return b, nil
+}
+
+// number of bytes an instruction takes in the given mode
+func instructionSize(mode AddressMode) uint8 {
+	switch mode {
+	case AM_IMPLIED, AM_ACCUMULATOR:
+		return 1
+	case AM_ABSOLUTE, AM_ABSOLUTE_X, AM_ABSOLUTE_Y, AM_INDIRECT, AM_ABSOLUTE_X_INDIRECT:
+		return 3
+	default:
+		return 2
+	}
+}
+
+func isZeroPage(mode AddressMode) bool {
+	return mode == AM_ZEROPAGE || mode == AM_ZEROPAGE_X || mode == AM_ZEROPAGE_Y
+}
+
+func parseNumber(s string) (uint16, error) {
+	var value uint64
+	var err error
+
+	switch {
+	case strings.HasPrefix(s, "$"):
+		value, err = strconv.ParseUint(s[1:], 16, 16)
+	case strings.HasPrefix(s, "%"):
+		value, err = strconv.ParseUint(s[1:], 2, 16)
+	default:
+		value, err = strconv.ParseUint(s, 10, 16)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	return uint16(value), nil
+}
+
+// labels start with a letter or underscore followed by letters, digits or underscores
+func validLabel(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && c >= '0' && c <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
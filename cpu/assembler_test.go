@@ -0,0 +1,331 @@
+package cpu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	program, err := Assemble(`
+		; count x down to zero
+		start:
+			LDX #$03
+		loop:	DEX
+			STX $10
+			STA table,X
+			LDA ($20),Y
+			BNE loop
+			JMP start
+		table:
+	`, ProgramStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []uint8{
+		0xa2, 0x03, // LDX #$03
+		0xca,       // DEX
+		0x86, 0x10, // STX $10
+		0x9d, 0x0f, 0xdd, // STA table,X
+		0xb1, 0x20, // LDA ($20),Y
+		0xd0, 0xf6, // BNE loop
+		0x4c, 0x00, 0xdd, // JMP start
+	}
+
+	if !bytes.Equal(program.Bytes, expected) {
+		t.Errorf("expected % x got % x", expected, program.Bytes)
+	}
+
+	symbols := map[uint16]string{
+		0xdd00: "start",
+		0xdd02: "loop",
+		0xdd0f: "table",
+	}
+	for address, name := range symbols {
+		if program.Symbols[address] != name {
+			t.Errorf("expected %s at %04x got %q", name, address, program.Symbols[address])
+		}
+	}
+}
+
+func TestAssembleCMOS(t *testing.T) {
+	program, err := Assemble(`
+			JMP ($1234,X)
+			ASL A
+			BIT #$01
+			INC A
+			DEC
+			WAI
+			STP
+	`, ProgramStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []uint8{
+		0x7c, 0x34, 0x12, // JMP ($1234,X)
+		0x0a,       // ASL A
+		0x89, 0x01, // BIT #$01
+		0x1a, // INC A
+		0x3a, // DEC
+		0xcb, // WAI
+		0xdb, // STP
+	}
+
+	if !bytes.Equal(program.Bytes, expected) {
+		t.Errorf("expected % x got % x", expected, program.Bytes)
+	}
+}
+
+func TestAssembleDirectives(t *testing.T) {
+	program, err := Assemble(`
+		.org $c000
+		reset:	LDX #$00
+		loop:	LDA message,X
+			STA $0200,X
+			INX
+			CPX #$03
+			BNE loop
+		done:	JMP done
+		message: .byte $48, $49, 33
+
+		.org $fffa
+			.word reset, reset, reset ; NMI, RES, IRQ
+	`, 0x0000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect16(t, program.Origin, newUint16(0xc000))
+	if len(program.Bytes) != 0x4000 {
+		t.Fatalf("expected 0x4000 bytes got %04x", len(program.Bytes))
+	}
+
+	memory := &Memory{}
+	program.Load(memory)
+
+	expect16(t, memory.ReadWord(RESVectorLow), newUint16(0xc000))
+	expect16(t, memory.ReadWord(NMIVectorLow), newUint16(0xc000))
+
+	cpu := NewMOS6502()
+	cpu.Reset(memory)
+	cpu.TrapDetector = true
+
+	for i := 0; i < 100 && cpu.Halt() == Continue; i++ {
+		cpu.Step()
+	}
+
+	if cpu.Halt() != HaltTrap {
+		t.Fatalf("expected to end in the done loop got %s", cpu.Halt())
+	}
+	if !bytes.Equal(memory[0x0200:0x0203], []uint8{'H', 'I', '!'}) {
+		t.Errorf("expected HI! got % x", memory[0x0200:0x0203])
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, src := range []string{
+		"FOO",
+		"LDA",
+		"LDA #$100",
+		"JMP nowhere",
+		"a: NOP\na: NOP",
+		"STA #$10",
+		".byte $100",
+		".word",
+		".org nowhere",
+		".foo 1",
+		".org $ffff\nJMP $1234",
+	} {
+		if _, err := Assemble(src, ProgramStart); err == nil {
+			t.Errorf("expected error assembling %q", src)
+		}
+	}
+}
+
+func TestDisassembleSymbols(t *testing.T) {
+	program, err := Assemble(`
+			LDX #$03
+		loop:	DEX
+			BNE loop
+			JMP loop
+	`, ProgramStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := setup(program.Bytes, nil)
+
+	// without symbols we get the raw address
+	if d := cpu.Disassemble(0xdd03); d.Disassembly != "BNE $DD02" {
+		t.Errorf("expected BNE $DD02 got %q", d.Disassembly)
+	}
+
+	cpu.Symbols = program.Symbols
+
+	if d := cpu.Disassemble(0xdd03); d.Disassembly != "BNE loop" {
+		t.Errorf("expected BNE loop got %q", d.Disassembly)
+	}
+	if d := cpu.Disassemble(0xdd05); d.Disassembly != "JMP loop" {
+		t.Errorf("expected JMP loop got %q", d.Disassembly)
+	}
+}
+
+func TestDisassembleTarget(t *testing.T) {
+	memory := map[uint16]uint8{
+		0x10ff: 0x42,
+		0x1100: 0x23,
+		0x1000: 0x33,
+	}
+
+	for _, tc := range []struct {
+		variant Variant
+		target  uint16
+	}{
+		{NMOS6502, 0x3342},
+		{CMOS65C02, 0x2342},
+	} {
+		cpu := setup([]uint8{0x6c, 0xff, 0x10}, memory) // JMP ($10FF)
+		cpu.SetVariant(tc.variant)
+
+		d := cpu.Disassemble(ProgramStart)
+		if d.Disassembly != "JMP ($10FF)" {
+			t.Errorf("expected JMP ($10FF) got %q", d.Disassembly)
+		}
+		expect16(t, d.Target, &tc.target)
+
+		// matches where executing it goes
+		cpu.Step()
+		expect16(t, cpu.pc, &tc.target)
+	}
+
+	cpu := setup([]uint8{
+		0x20, 0x34, 0x12, // JSR $1234
+		0xd0, 0xfb, // BNE $DD00
+		0xe8, // INX
+	}, nil)
+	expect16(t, cpu.Disassemble(ProgramStart).Target, newUint16(0x1234))
+	expect16(t, cpu.Disassemble(ProgramStart+3).Target, newUint16(ProgramStart))
+	expect16(t, cpu.Disassemble(ProgramStart+5).Target, newUint16(0x0000))
+}
+
+func TestDisassembleRelative(t *testing.T) {
+	cpu := setup([]uint8{
+		0xd0, 0xf6, // BNE $DCF8
+		0xf0, 0x10, // BEQ $DD14
+	}, map[uint16]uint8{
+		// the byte after the offset is not part of it
+		0xdd04: 0xff,
+	})
+
+	for _, tc := range []struct {
+		address     uint16
+		operand     uint16
+		target      uint16
+		disassembly string
+	}{
+		{ProgramStart, 0xf6, 0xdcf8, "BNE $DCF8"},
+		{ProgramStart + 2, 0x10, 0xdd14, "BEQ $DD14"},
+	} {
+		d := cpu.Disassemble(tc.address)
+		expect16(t, d.Operand, &tc.operand)
+		expect16(t, d.Target, &tc.target)
+		if d.Disassembly != tc.disassembly {
+			t.Errorf("expected %s got %q", tc.disassembly, d.Disassembly)
+		}
+	}
+
+	// with the offsets shown as well
+	cpu.ShowBranchOffsets = true
+	for address, expected := range map[uint16]string{
+		ProgramStart:     "BNE $DCF8 ; $F6",
+		ProgramStart + 2: "BEQ $DD14 ; $10",
+	} {
+		if d := cpu.Disassemble(address); d.Disassembly != expected {
+			t.Errorf("expected %s got %q", expected, d.Disassembly)
+		}
+	}
+}
+
+func TestDisassembleUnknown(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x01, // LDA #$01
+		0x02,             // not an instruction on the NMOS 6502
+		0x8d, 0x00, 0x02, // STA $0200
+	}, nil)
+
+	var lines []string
+	for address := ProgramStart; address < ProgramStart+6; {
+		d := cpu.Disassemble(address)
+		lines = append(lines, d.Disassembly)
+		address += uint16(d.Size)
+	}
+
+	expected := []string{"LDA #$01", ".BYTE $02", "STA $0200"}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q got %q", expected, lines)
+	}
+
+	d := cpu.Disassemble(ProgramStart + 2)
+	if d.Opcode != OPC_BYTE || d.Size != 1 || d.Operand != 0x02 {
+		t.Errorf("expected a 1 byte .BYTE $02 got %+v", d)
+	}
+}
+
+func TestNewFromAssembly(t *testing.T) {
+	cpu, err := NewFromAssembly(`
+			LDA #$00
+			LDX #$05
+		loop:	CLC
+			ADC #$03
+			DEX
+			BNE loop
+			STA $10
+		done:	JMP done
+	`, 0x0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect16(t, cpu.pc, newUint16(0x0600))
+
+	cpu.TrapDetector = true
+	cpu.Quiet = true
+	if halt := cpu.RunInstructions(100); halt != HaltTrap {
+		t.Fatalf("expected to end in the done loop got %s", halt)
+	}
+	expect8(t, cpu.memory[0x10], newUint8(15))
+	if d := cpu.Disassemble(cpu.pc); d.Disassembly != "JMP done" {
+		t.Errorf("expected symbols to be set got %q", d.Disassembly)
+	}
+
+	// a program with its own vectors keeps them
+	cpu, err = NewFromAssembly(`
+		start:	NOP
+		.org $c000
+		reset:	NOP
+		.org $fffc
+			.word reset
+	`, 0x0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect16(t, cpu.pc, newUint16(0xc000))
+
+	// assembling past the reset vector leaves it zero in the gap, it is
+	// still set to the origin
+	cpu, err = NewFromAssembly(`
+		.org $c000
+		reset:	NOP
+		.org $fffe
+			.word reset
+	`, 0x0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect16(t, cpu.pc, newUint16(0x0600))
+
+	if _, err := NewFromAssembly("FOO", 0x0600); err == nil {
+		t.Error("expected an error from bad source")
+	}
+}
@@ -0,0 +1,89 @@
+package cpu
+
+import (
+	"fmt"
+)
+
+// Bus is what the cpu reads and writes memory through, allowing devices
+// to be mapped over the top of memory. Memory itself is a Bus
+type Bus interface {
+	Read(address uint16) uint8
+	Write(address uint16, value uint8)
+}
+
+// Peeker is implemented by a Bus that can read without side effects. false
+// is returned for addresses owned by a device where reading or writing does
+// something, i.e. taking a received byte
+type Peeker interface {
+	Peek(address uint16) (uint8, bool)
+}
+
+// peek through bus if it supports it
+func peekBus(bus Bus, address uint16) (uint8, bool) {
+	if p, ok := bus.(Peeker); ok {
+		return p.Peek(address)
+	}
+	return 0, false
+}
+
+// SetBus routes all memory accesses through the bus. A nil bus reads
+// and writes the memory passed to Reset directly
+func (cpu *MOS6502) SetBus(bus Bus) {
+	cpu.bus = bus
+}
+
+// DeviceBus dispatches reads and writes to the devices mapped over address
+// ranges, anything unmapped goes to the fallback, usually Memory. devices
+// are passed the full address
+type DeviceBus struct {
+	fallback Bus
+	mappings []mapping
+}
+
+// device mapped over start to end inclusive
+type mapping struct {
+	start, end uint16
+	device     Bus
+}
+
+func NewDeviceBus(fallback Bus) *DeviceBus {
+	return &DeviceBus{
+		fallback: fallback,
+	}
+}
+
+// Map routes start to end (inclusive) to device, erroring if the range is
+// backwards or overlaps an existing mapping
+func (b *DeviceBus) Map(start, end uint16, device Bus) error {
+	if end < start {
+		return fmt.Errorf("end %04x is before start %04x", end, start)
+	}
+	for _, m := range b.mappings {
+		if start <= m.end && end >= m.start {
+			return fmt.Errorf("%04x-%04x overlaps %04x-%04x", start, end, m.start, m.end)
+		}
+	}
+	b.mappings = append(b.mappings, mapping{start: start, end: end, device: device})
+	return nil
+}
+
+func (b *DeviceBus) lookup(address uint16) Bus {
+	for _, m := range b.mappings {
+		if address >= m.start && address <= m.end {
+			return m.device
+		}
+	}
+	return b.fallback
+}
+
+func (b *DeviceBus) Read(address uint16) uint8 {
+	return b.lookup(address).Read(address)
+}
+
+func (b *DeviceBus) Write(address uint16, value uint8) {
+	b.lookup(address).Write(address, value)
+}
+
+func (b *DeviceBus) Peek(address uint16) (uint8, bool) {
+	return peekBus(b.lookup(address), address)
+}
@@ -0,0 +1,83 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// device that reads back a fixed value and remembers the last write
+type fixedDevice struct {
+	value   uint8
+	written map[uint16]uint8
+}
+
+func (d *fixedDevice) Read(address uint16) uint8 {
+	return d.value
+}
+
+func (d *fixedDevice) Write(address uint16, value uint8) {
+	if d.written == nil {
+		d.written = make(map[uint16]uint8)
+	}
+	d.written[address] = value
+}
+
+func TestDeviceBus(t *testing.T) {
+	cpu := setup([]uint8{
+		0xad, 0x00, 0xd0, // LDA $d000
+		0xae, 0x10, 0xd0, // LDX $d010
+		0xac, 0x20, 0xd0, // LDY $d020
+		0x8d, 0x0f, 0xd0, // STA $d00f
+		0x8d, 0x20, 0xd0, // STA $d020
+	}, map[uint16]uint8{0xd020: 0x33})
+
+	first := &fixedDevice{value: 0x11}
+	second := &fixedDevice{value: 0x22}
+
+	bus := NewDeviceBus(cpu.memory)
+	if err := bus.Map(0xd000, 0xd00f, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Map(0xd010, 0xd01f, second); err != nil {
+		t.Fatal(err)
+	}
+	cpu.SetBus(bus)
+
+	for i := 0; i < 5; i++ {
+		cpu.Step()
+	}
+
+	expect8(t, cpu.a, newUint8(0x11))
+	expect8(t, cpu.x, newUint8(0x22))
+	// unmapped addresses fall back to memory
+	expect8(t, cpu.y, newUint8(0x33))
+
+	expect8(t, first.written[0xd00f], newUint8(0x11))
+	if len(second.written) != 0 {
+		t.Errorf("expected no writes to the second device got %v", second.written)
+	}
+	expect8(t, cpu.memory[0xd020], newUint8(0x11))
+}
+
+func TestDeviceBusMapErrors(t *testing.T) {
+	bus := NewDeviceBus(&Memory{})
+	if err := bus.Map(0xd000, 0xd00f, &fixedDevice{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range [][2]uint16{
+		{0xd00f, 0xd010}, // overlaps the end
+		{0xcff0, 0xd000}, // overlaps the start
+		{0xd004, 0xd008}, // inside
+		{0xc000, 0xdfff}, // around
+		{0xe010, 0xe000}, // backwards
+	} {
+		if err := bus.Map(r[0], r[1], &fixedDevice{}); err == nil {
+			t.Errorf("expected an error mapping %04x-%04x", r[0], r[1])
+		}
+	}
+
+	// adjacent ranges are fine
+	if err := bus.Map(0xd010, 0xd010, &fixedDevice{}); err != nil {
+		t.Error(err)
+	}
+}
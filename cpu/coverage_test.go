@@ -0,0 +1,76 @@
+package cpu
+
+import (
+	cliflag "flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"testing"
+)
+
+// the package has its own flag type so the flag package is renamed
+var reportOpcodes = cliflag.Bool("opcodes", false, "report the documented opcodes the tests never execute, needs -v to be shown")
+
+// opcodes started by any cpu built with setup
+var executed [0x100]bool
+
+// OnCycle hook marking the opcode at the pc as executed when an instruction
+// is about to start
+func recordOpcode(cpu *MOS6502) {
+	if !cpu.Busy() {
+		executed[cpu.memory[cpu.pc]] = true
+	}
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if *reportOpcodes {
+		opcodeReport(os.Stdout)
+	}
+	os.Exit(code)
+}
+
+// print how many of the documented NMOS opcodes were executed followed by
+// any that were not
+func opcodeReport(w io.Writer) {
+	cpu := NewMOS6502()
+
+	var total int
+	var missing []string
+	for opcode, instruction := range cpu.instructions {
+		if instruction == nil || instruction.undocumented {
+			continue
+		}
+		total++
+		if !executed[opcode] {
+			missing = append(missing, fmt.Sprintf("%02x %s", opcode, cpu.disassembleMode(instruction)))
+		}
+	}
+	sort.Strings(missing)
+
+	fmt.Fprintf(w, "executed %d of %d documented opcodes\n", total-len(missing), total)
+	for _, m := range missing {
+		fmt.Fprintf(w, "\tnot executed: %s\n", m)
+	}
+}
+
+// mnemonic and a placeholder operand showing the addressing mode
+func (cpu *MOS6502) disassembleMode(instruction *instruction) string {
+	modes := map[AddressMode]string{
+		AM_IMMEDIATE:           " #$nn",
+		AM_ZEROPAGE:            " $nn",
+		AM_ZEROPAGE_X:          " $nn,X",
+		AM_ZEROPAGE_Y:          " $nn,Y",
+		AM_ABSOLUTE:            " $nnnn",
+		AM_ABSOLUTE_X:          " $nnnn,X",
+		AM_ABSOLUTE_Y:          " $nnnn,Y",
+		AM_INDIRECT:            " ($nnnn)",
+		AM_INDIRECT_X:          " ($nn,X)",
+		AM_INDIRECT_Y:          " ($nn),Y",
+		AM_RELATIVE:            " $nn",
+		AM_ACCUMULATOR:         " A",
+		AM_ABSOLUTE_X_INDIRECT: " ($nnnn,X)",
+	}
+	return string(instruction.opc) + modes[instruction.mode]
+}
@@ -1,6 +1,7 @@
 package cpu
 
 import (
+	"context"
 	"fmt"
 	"log"
 )
@@ -19,6 +20,9 @@ const (
 	StackOffset uint16 = 0x0100
 	StackBottom uint8  = 0x00
 	StackTop    uint8  = 0xff
+	// reset runs the interrupt sequence with the pushes turned in to reads
+	// so the stack pointer still moves down three from $00
+	StackReset uint8 = 0xfd
 )
 
 // describe the kind of halt received
@@ -29,8 +33,40 @@ const (
 	HaltSuccess
 	HaltTrap
 	HaltUnknownInstruction
+	HaltStackGuard
+	HaltWaitingForInterrupt
+	HaltStopped
+	HaltCancelled
+	HaltExecutingVectors
+	HaltPaused
 )
 
+func (h HaltType) String() string {
+	switch h {
+	case Continue:
+		return "continue"
+	case HaltSuccess:
+		return "success"
+	case HaltTrap:
+		return "trap"
+	case HaltUnknownInstruction:
+		return "unknown instruction"
+	case HaltStackGuard:
+		return "stack guard"
+	case HaltWaitingForInterrupt:
+		return "waiting for interrupt"
+	case HaltStopped:
+		return "stopped"
+	case HaltCancelled:
+		return "cancelled"
+	case HaltExecutingVectors:
+		return "executing vectors"
+	case HaltPaused:
+		return "paused"
+	}
+	return fmt.Sprintf("HaltType(%d)", uint8(h))
+}
+
 type MOS6502 struct {
 	// main register
 	a uint8
@@ -51,23 +87,107 @@ type MOS6502 struct {
 	// instruction table
 	instructions [0x100]*instruction
 
+	// instruction being executed, nil while entering an interrupt
+	current *instruction
+
+	// which chip we are emulating
+	variant Variant
+
 	// memory thats set on reset
 	memory *Memory
 
+	// optional bus that all memory accesses go through
+	bus Bus
+
 	// halt the cpu
 	halt HaltType
 
+	// pending interrupts
+	irq bool
+	nmi bool
+
+	// RDY is held low, see SetReady
+	stalled bool
+
+	// the host has stopped the clock, see Pause
+	paused bool
+
+	// register values set on reset, these default to 0
+	PowerOnA uint8
+	PowerOnX uint8
+	PowerOnY uint8
+
+	// called at the start of every clock cycle
+	OnCycle func(cpu *MOS6502)
+	// called when a BRK executes with the pc past its signature byte,
+	// returning true skips the interrupt so BRK can be used as a system
+	// call with the registers as arguments
+	OnBRK func(cpu *MOS6502) bool
+	// called with each opcode as it is fetched, see OnFetch
+	onFetch func(pc uint16, opcode uint8)
+
 	// print out step debug information
 	Debug bool
+	// layout of the Debug lines
+	TraceFormat TraceFormat
+	// reuse the Debug disassembly of instructions that have already run,
+	// writes through the cpu to an instruction's bytes invalidate it but
+	// writing to Memory directly or changing Symbols or ShowBranchOffsets
+	// does not
+	CacheDisassembly bool
+	disasmCache      map[uint16]string
+	// called with every instruction, see AddTraceSink
+	traceSinks []func(TraceEntry)
+	// do not log when halting on an unknown opcode or trap, the halt
+	// still happens
+	Quiet bool
+	// label names used by the disassembler, keyed by address
+	Symbols map[uint16]string
+	// follow a branch's target with its raw offset, i.e. BNE $DCF8 ; $F6.
+	// off by default so traces can be diffed against nestest.log
+	ShowBranchOffsets bool
+	// halt if entering an interrupt would push the stack pointer below
+	// this, catching runaway nesting before the stack wraps. 0 disables
+	StackGuard uint8
+	// halt if the pc reaches the vectors at $fffa-$ffff, which is almost
+	// always a runaway pc rather than code
+	GuardVectorExecution bool
 	// detect if we are in a trap loop
 	TrapDetector bool
+	// called with the pc of a trap before halting, giving a chance to
+	// log the loop
+	OnTrap       func(pc uint16)
 	trapDetector trapDetector
 
+	// ignore writes at or above ROMStart, i.e. $8000 for 32k of RAM
+	// below 32k of ROM
+	ProtectROM bool
+	ROMStart   uint16
+	// called with any write that was ignored
+	OnROMWrite func(address uint16, value uint8)
+
+	// count every read and write by address, see AccessCounts
+	CountAccesses bool
+	reads         *[0x10000]uint64
+	writes        *[0x10000]uint64
+	// count every executed instruction, see InstructionHistogram
+	CountInstructions bool
+	executed          map[OPCode]uint64
+
+	// recent states for StepBack, nil unless enabled
+	history *history
+
+	// sentinel for reads of unwritten memory, nil unless enabled
+	poison *poison
+
 	// catpure the number of additional cycles
 	additionalCycles uint8
 
 	// total cycle count
 	TotalCycles uint64
+	// every instruction takes its base cycles, ignoring page crosses, taken
+	// branches and other data dependent extra cycles
+	SimpleTiming bool
 
 	// last test
 	StopOnPC uint16
@@ -83,49 +203,300 @@ func NewMOS6502() *MOS6502 {
 }
 
 func (cpu *MOS6502) Reset(memory *Memory) {
-	// reset registers
-	cpu.a = 0xaa
-	cpu.x = 0x0
-	cpu.y = 0x0
+	// reset registers, real hardware leaves these undefined so use
+	// the configured power on values
+	cpu.a = cpu.PowerOnA
+	cpu.x = cpu.PowerOnX
+	cpu.y = cpu.PowerOnY
 	// reset stack pointer
-	cpu.sp = StackTop
+	cpu.sp = StackReset
 	// reset flags  http://forum.6502.org/viewtopic.php?t=829
 	//    7   6   5   4   3   2   1   0
 	//    N   V       B   D   I   Z   C
-	//    *   *   1   1   0   1   *   *
+	//    *   *   1   1   *   1   *   *
+	//
+	// the 65C02 clears D on reset, the NMOS 6502 leaves it as it was
+	// which on power on is undefined, here it starts clear
+	decimal := cpu.p.isSet(P_Decimal) && !cpu.cmos()
 	cpu.p = 0b00110100
-
-	cpu.pc = memory.ReadWord(0xfffc)
+	cpu.p.set(P_Decimal, decimal)
 
 	cpu.memory = memory
 	cpu.wait = 0
+	cpu.current = nil
+	cpu.halt = Continue
+
+	// drop any pending interrupts
+	cpu.irq = false
+	cpu.nmi = false
+
+	// pcs from before the reset are not part of a loop
+	cpu.trapDetector.reset()
+
+	// memory may have been swapped out
+	cpu.disasmCache = nil
+
+	// history from before the reset can not be stepped back through
+	if cpu.history != nil {
+		cpu.history.size = 0
+	}
+
+	cpu.pc = cpu.readWord(RESVectorLow)
+}
+
+// ResetCPU pulls the reset line, resetting the cpu but keeping its memory
+func (cpu *MOS6502) ResetCPU() {
+	cpu.Reset(cpu.memory)
+}
+
+// CheckResetVector returns ErrBadResetVector if the reset vector points at
+// an opcode the cpu does not know, usually because nothing was loaded there
+func (cpu *MOS6502) CheckResetVector() error {
+	vector := cpu.memory.ReadWord(RESVectorLow)
+	if opcode := cpu.memory.Read(vector); cpu.instructions[opcode] == nil {
+		return fmt.Errorf("%w: %04x has unknown opcode %02x", ErrBadResetVector, vector, opcode)
+	}
+	return nil
 }
 
 func (cpu *MOS6502) SetPC(pc uint16) {
 	cpu.pc = pc
 }
 
+// PC returns the address of the next instruction to execute
+func (cpu *MOS6502) PC() uint16 {
+	return cpu.pc
+}
+
 func (cpu *MOS6502) Halt() HaltType {
 	return cpu.halt
 }
 
+// Busy reports if the cpu is still working through the cycles of the
+// current instruction
+func (cpu *MOS6502) Busy() bool {
+	return cpu.wait > 0
+}
+
+// RemainingCycles returns the number of cycles left before the current
+// instruction finishes
+func (cpu *MOS6502) RemainingCycles() uint8 {
+	return cpu.wait
+}
+
+// Step executes the next instruction in full, ignoring any cycles the
+// cpu would otherwise have waited on
+func (cpu *MOS6502) Step() {
+	if cpu.paused {
+		return
+	}
+
+	cpu.wait = 0
+	cpu.Cycle()
+	cpu.wait = 0
+}
+
+// StepTrace disassembles the instruction at the pc and then steps over it,
+// returning an error if the cpu halted or is paused
+func (cpu *MOS6502) StepTrace() (*DisassembledInstruction, error) {
+	disasm := cpu.Disassemble(cpu.pc)
+	if cpu.paused {
+		return disasm, ErrPaused
+	}
+
+	cpu.Step()
+
+	if cpu.halt != Continue {
+		return disasm, fmt.Errorf("cpu halted at %04x: %s", cpu.pc, cpu.halt)
+	}
+
+	return disasm, nil
+}
+
+// CallSubroutine calls the subroutine at address as if by a JSR from the
+// current pc and steps until its RTS returns to the pc with the stack as it
+// was. an error is returned if the cpu halts or maxCycles are used first,
+// or ErrPaused without calling it if the cpu is paused
+func (cpu *MOS6502) CallSubroutine(address uint16, maxCycles uint64) error {
+	if cpu.paused {
+		return ErrPaused
+	}
+
+	ret := cpu.pc
+	sp := cpu.sp
+
+	// jsr pushes the address of its last byte, rts adds one when popping
+	lo, hi := Bytes(ret - 1)
+	cpu.push(hi)
+	cpu.push(lo)
+	cpu.pc = address
+
+	start := cpu.TotalCycles
+	for cpu.pc != ret || cpu.sp != sp {
+		if cpu.TotalCycles-start >= maxCycles {
+			return fmt.Errorf("subroutine at %04x did not return within %d cycles", address, maxCycles)
+		}
+
+		cpu.Step()
+
+		if cpu.halt != Continue {
+			return fmt.Errorf("cpu halted at %04x: %s", cpu.pc, cpu.halt)
+		}
+	}
+
+	return nil
+}
+
+// RunFrame clocks the cpu for at least cyclesPerFrame cycles, finishing
+// the instruction in progress so one is never split across frames. it
+// returns early if the cpu halts, or with HaltPaused if the cpu is paused
+func (cpu *MOS6502) RunFrame(cyclesPerFrame uint64) HaltType {
+	if cpu.paused {
+		return HaltPaused
+	}
+	for n := uint64(0); n < cyclesPerFrame || cpu.wait > 0; n++ {
+		if cpu.halt != Continue {
+			break
+		}
+		cpu.Cycle()
+	}
+	return cpu.halt
+}
+
+// SetReady drives the RDY line. while it is not ready every Cycle is
+// stolen, i.e. for DMA, and the cpu makes no progress until it is ready
+// again. the whole instruction runs on its first cycle here so cycles are
+// stolen between instructions or from their wait, rather than only on
+// read cycles as on the real chip
+func (cpu *MOS6502) SetReady(ready bool) {
+	cpu.stalled = !ready
+}
+
+// Pause stops the clock from the host's side, i.e. when the user pauses an
+// emulator. unlike SetReady the ticks never reach the cpu so they are not
+// counted, and an instruction part way through its cycles is left as it is
+// until Resume. the Run functions return HaltPaused straight away while
+// paused
+func (cpu *MOS6502) Pause() {
+	cpu.paused = true
+}
+
+// Resume starts the clock again after Pause
+func (cpu *MOS6502) Resume() {
+	cpu.paused = false
+}
+
+// Paused returns true between Pause and Resume
+func (cpu *MOS6502) Paused() bool {
+	return cpu.paused
+}
+
+// cycles between checks of the context in RunContext, keeping the cost of
+// checking it out of the common path
+const contextCheckCycles = 0x1000
+
+// RunContext clocks the cpu until it halts or ctx is done, returning
+// HaltCancelled in the latter case. the cpu itself is not halted so it
+// can be run again, picking up where it stopped
+func (cpu *MOS6502) RunContext(ctx context.Context) HaltType {
+	if cpu.paused {
+		return HaltPaused
+	}
+	for cpu.halt == Continue {
+		if ctx.Err() != nil {
+			return HaltCancelled
+		}
+		for i := 0; i < contextCheckCycles && cpu.halt == Continue; i++ {
+			cpu.Cycle()
+		}
+	}
+	return cpu.halt
+}
+
+// RunInstructions steps through up to n instructions, stopping early if the
+// cpu halts, or with HaltPaused if the cpu is paused
+func (cpu *MOS6502) RunInstructions(n int) HaltType {
+	if cpu.paused {
+		return HaltPaused
+	}
+	for i := 0; i < n && cpu.halt == Continue; i++ {
+		cpu.Step()
+	}
+	return cpu.halt
+}
+
+// Cycle runs a single clock cycle. The instruction is executed on its
+// first cycle and the cpu then waits out the cycles it takes
 func (cpu *MOS6502) Cycle() {
+	if cpu.paused {
+		return
+	}
+
+	if cpu.OnCycle != nil {
+		cpu.OnCycle(cpu)
+	}
+
+	// the clock keeps running while stalled but nothing progresses
+	if cpu.stalled {
+		cpu.TotalCycles++
+		return
+	}
+
+	// still working through the current instruction
+	if cpu.wait > 0 {
+		cpu.wait--
+		return
+	}
+
+	cpu.next()
+}
+
+// start the next instruction or interrupt. kept out of Cycle so the wait
+// cycles, which are most of them, stay cheap
+func (cpu *MOS6502) next() {
+	// WAI and STP stop the clock until an interrupt or reset
+	if cpu.halt == HaltWaitingForInterrupt || cpu.halt == HaltStopped {
+		return
+	}
+
 	if cpu.pc == uint16(cpu.StopOnPC) {
 		cpu.halt = HaltSuccess
 		return
 	}
 
+	if cpu.GuardVectorExecution && cpu.pc >= NMIVectorLow {
+		cpu.halt = HaltExecutingVectors
+		if !cpu.Quiet {
+			log.Printf("executing the vectors at %04x", cpu.pc)
+		}
+		return
+	}
+
+	if cpu.history != nil {
+		cpu.snapshot()
+	}
+
+	// interrupts are taken between instructions
+	if (cpu.nmi || cpu.irq) && cpu.serviceInterrupt() {
+		return
+	}
+
 	// reset state
 	cpu.additionalCycles = 0
 
 	// pop the 8bit opcode and progress the pc
-	opcode := cpu.memory.Read(cpu.pc)
+	opcode := cpu.read(cpu.pc)
+	if cpu.onFetch != nil {
+		cpu.onFetch(cpu.pc, opcode)
+	}
 
 	// read the instruction from the table halting if not found
 	instruction := cpu.instructions[opcode]
 	if instruction == nil {
 		cpu.halt = HaltUnknownInstruction
-		log.Printf("no instruction found for opcode %02x at %04x", opcode, opcode)
+		if !cpu.Quiet {
+			log.Printf("no instruction found for opcode %02x at %04x", opcode, cpu.pc)
+		}
 		return
 	}
 
@@ -133,25 +504,22 @@ func (cpu *MOS6502) Cycle() {
 	address := instruction.load(cpu)
 
 	if cpu.Debug {
-		disasm := cpu.disassembleInstruction(cpu.pc)
-		log.Printf(
-			"%04x : %02x\t%-30s\t%s\tA:%02x X:%02x Y:%02x\tSP:%04x",
-			cpu.pc,
-			opcode,
-			disasm.Disassembly,
-			cpu.p.String(),
-			cpu.a,
-			cpu.x,
-			cpu.y,
-			cpu.sp,
-		)
+		log.Print(cpu.traceLine(opcode, instruction))
+	}
+	if len(cpu.traceSinks) > 0 {
+		cpu.trace(opcode)
 	}
 
 	if cpu.TrapDetector {
 		cpu.trapDetector.push(cpu.pc)
 		if cpu.trapDetector.hastrap() {
+			if cpu.OnTrap != nil {
+				cpu.OnTrap(cpu.pc)
+			}
 			cpu.halt = HaltTrap
-			log.Printf("trap detected at %04x", cpu.pc)
+			if !cpu.Quiet {
+				log.Printf("trap detected at %04x", cpu.pc)
+			}
 			return
 		}
 	}
@@ -159,26 +527,132 @@ func (cpu *MOS6502) Cycle() {
 	// increment the pc by the size of the instruction
 	cpu.pc += uint16(instruction.size)
 
-	// mark the cpu busy for the number of cycles the instruction takes (- this cycle)
-	cpu.TotalCycles += uint64(instruction.cycles + cpu.additionalCycles)
+	if cpu.CountInstructions {
+		if cpu.executed == nil {
+			cpu.executed = make(map[OPCode]uint64)
+		}
+		cpu.executed[instruction.opc]++
+	}
 
+	cpu.current = instruction
 	instruction.execute(address)
+
+	if cpu.SimpleTiming {
+		cpu.additionalCycles = 0
+	}
+
+	// mark the cpu busy for the number of cycles the instruction takes (- this
+	// cycle), after execute so taken branches and decimal mode are counted
+	cpu.TotalCycles += uint64(instruction.cycles + cpu.additionalCycles)
+	cpu.wait = instruction.cycles + cpu.additionalCycles - 1
+}
+
+// OnFetch calls fn with the pc and opcode of every instruction as it is
+// fetched, before it executes. unlike Debug it is cheap enough to leave on
+// for building coverage maps or finding jump targets. nil removes it
+func (cpu *MOS6502) OnFetch(fn func(pc uint16, opcode uint8)) {
+	cpu.onFetch = fn
 }
 
 func stackAddress(sp uint8) uint16 {
 	return (StackOffset | uint16(sp))
 }
 
+// StackPointerAddress returns the address the next push will write to. the
+// stack pointer is 8 bits and always points in to page 1 ($0100-$01ff)
+func (cpu *MOS6502) StackPointerAddress() uint16 {
+	return stackAddress(cpu.sp)
+}
+
+// ZeroPage returns a copy of page zero
+func (cpu *MOS6502) ZeroPage() [256]uint8 {
+	var page [256]uint8
+	copy(page[:], cpu.memory[:0x100])
+	return page
+}
+
+// Stack returns a copy of the live portion of the stack, from the most
+// recently pushed byte up to the top. an empty stack returns no bytes
+func (cpu *MOS6502) Stack() []uint8 {
+	stack := make([]uint8, StackTop-cpu.sp)
+	copy(stack, cpu.memory[stackAddress(cpu.sp)+1:])
+	return stack
+}
+
+// read a byte through the bus if one is set, otherwise from memory
+func (cpu *MOS6502) read(address uint16) uint8 {
+	if cpu.CountAccesses {
+		cpu.count(&cpu.reads, address)
+	}
+	if cpu.poison != nil {
+		if value, ok := cpu.poison.read(address); ok {
+			return value
+		}
+	}
+	return cpu.peek(address)
+}
+
+// read a byte without counting it
+func (cpu *MOS6502) peek(address uint16) uint8 {
+	if cpu.bus != nil {
+		return cpu.bus.Read(address)
+	}
+	return cpu.memory[address]
+}
+
+// read a little endian word
+func (cpu *MOS6502) readWord(address uint16) uint16 {
+	return Word(cpu.read(address), cpu.read(address+1))
+}
+
+// read a little endian word from the zeropage, a pointer at $ff takes
+// its high byte from $00 rather than $0100
+func (cpu *MOS6502) readZeroPageWord(address uint8) uint16 {
+	return Word(cpu.read(uint16(address)), cpu.read(uint16(address+1)))
+}
+
+// write a byte through the bus if one is set, otherwise to memory
+func (cpu *MOS6502) write(address uint16, value uint8) {
+	if cpu.ProtectROM && address >= cpu.ROMStart {
+		if cpu.OnROMWrite != nil {
+			cpu.OnROMWrite(address, value)
+		}
+		return
+	}
+	if cpu.history != nil {
+		cpu.recordWrite(address)
+	}
+	if cpu.CountAccesses {
+		cpu.count(&cpu.writes, address)
+	}
+	if cpu.disasmCache != nil {
+		cpu.invalidateDisassembly(address)
+	}
+	if cpu.poison != nil {
+		cpu.poison.mark(address)
+	}
+	cpu.store(address, value)
+}
+
+// write a byte without recording it in the history
+func (cpu *MOS6502) store(address uint16, value uint8) {
+	if cpu.bus != nil {
+		cpu.bus.Write(address, value)
+		return
+	}
+	cpu.memory[address] = value
+}
+
 // push a byte onto the stack if we overflow wrap around to the top of the stack
 func (cpu *MOS6502) push(b uint8) {
-	cpu.memory[stackAddress(cpu.sp)] = b
+	cpu.write(stackAddress(cpu.sp), b)
 	cpu.sp--
 }
 
 // pop a byte off the stack. if we overflow wrap around to the bottom of the stack
 func (cpu *MOS6502) pop() uint8 {
 	cpu.sp++
-	b := cpu.memory[stackAddress(cpu.sp)]
+	b := cpu.read(stackAddress(cpu.sp))
 	return b
 }
 
@@ -1,8 +1,14 @@
 package cpu
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"log"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -13,14 +19,18 @@ const DebugTests = false
 
 // setup a program within a cpu and return it
 func setup(program []uint8, bootstrap map[uint16]uint8) *MOS6502 {
-	memory := &Memory{}
+	return setupAt(ProgramStart, program, bootstrap)
+}
+
+// setup a program at start within a cpu and return it
+func setupAt(start uint16, program []uint8, bootstrap map[uint16]uint8) *MOS6502 {
+	memory := NewMemory()
 
 	// Reset vector
-	memory[RESVectorLow] = uint8(ProgramStart & 0xff)
-	memory[RESVectorHigh] = uint8(ProgramStart >> 8)
+	memory[RESVectorLow], memory[RESVectorHigh] = Bytes(start)
 
 	for i := 0; i < len(program); i++ {
-		memory[ProgramStart+uint16(i)] = program[i]
+		memory[start+uint16(i)] = program[i]
 	}
 
 	// map any memory over
@@ -31,6 +41,9 @@ func setup(program []uint8, bootstrap map[uint16]uint8) *MOS6502 {
 	cpu := NewMOS6502()
 	cpu.Reset(memory)
 	cpu.Debug = DebugTests
+	if *reportOpcodes {
+		cpu.OnCycle = recordOpcode
+	}
 
 	return cpu
 }
@@ -51,13 +64,13 @@ func setupUint16(register *uint16, v *uint16) {
 	*register = *v
 }
 
-// cycle a cpu n cycles
+// step a cpu through n-1 instructions
 func cycle(t *testing.T, cpu *MOS6502, n uint8) {
 	t.Helper()
 
 	var i uint8
 	for i = 1; i < n; i++ {
-		cpu.Cycle()
+		cpu.Step()
 	}
 }
 
@@ -71,6 +84,11 @@ func newUint16(v uint16) *uint16 {
 	return &v
 }
 
+// helper function to setup a uint64 pointer
+func newUint64(v uint64) *uint64 {
+	return &v
+}
+
 // helper function to setup a uint16 pointer
 func newBool(b bool) *bool {
 	return &b
@@ -97,6 +115,16 @@ func expect16(t *testing.T, a uint16, b *uint16) {
 	}
 }
 
+func expect64(t *testing.T, a uint64, b *uint64) {
+	t.Helper()
+	if b == nil {
+		return
+	}
+	if a != *b {
+		t.Errorf("expected: %d got: %d", *b, a)
+	}
+}
+
 // test case
 type testCase struct {
 	name string
@@ -105,6 +133,12 @@ type testCase struct {
 	// setup memory with any bootstrap values
 	memory map[uint16]uint8
 
+	// chip to emulate, defaults to the NMOS 6502
+	variant Variant
+
+	// where to load the program (nil means ProgramStart)
+	start *uint16
+
 	// setup registers (nil means we do not want to set)
 	setupA  *uint8
 	setupX  *uint8
@@ -139,8 +173,15 @@ type testCase struct {
 	expectSP *uint8
 	expectPC *uint16
 
-	// expectMemory to look like this
-	expectMemory map[uint16]uint8
+	// expect the total cycles taken (nil means we do not want to check)
+	expectTotalCycles *uint64
+
+	// expect exactly these addresses to have changed from the memory the
+	// test started with (nil means we do not want to check)
+	expectDelta map[uint16]uint8
+
+	// memory as it was before running
+	initial *Memory
 }
 
 // run a test case setting up state and then asserting
@@ -153,29 +194,17 @@ func (tc *testCase) setup(t *testing.T) *MOS6502 {
 	}
 
 	// setup state
-	cpu := setup(tc.program, tc.memory)
-
-	// setup program expected memory
-	if len(tc.expectMemory) > 0 {
-		for i := 0; i < len(tc.program); i++ {
-			tc.expectMemory[uint16(0xdd00+i)] = tc.program[i]
-		}
-		// play memory over the top
-		for address, b := range cpu.memory {
-			if b == 0 {
-				continue
-			}
-			// if we have a value already, prefer expected memory
-			if _, ok := tc.expectMemory[uint16(address)]; ok {
-				continue
-			}
-			tc.expectMemory[uint16(address)] = b
-		}
-		// add the reset vector
-		tc.expectMemory[0xfffc] = 0x00
-		tc.expectMemory[0xfffd] = 0xdd
+	start := ProgramStart
+	setupUint16(&start, tc.start)
+	cpu := setupAt(start, tc.program, tc.memory)
+	if tc.variant != NMOS6502 {
+		cpu.SetVariant(tc.variant)
 	}
 
+	// keep a copy to diff against
+	initial := *cpu.memory
+	tc.initial = &initial
+
 	setupUint8(&cpu.a, tc.setupA)
 	setupUint8(&cpu.x, tc.setupX)
 	setupUint8(&cpu.y, tc.setupY)
@@ -228,6 +257,7 @@ func (tc *testCase) run(t *testing.T, cpu *MOS6502) {
 	expect8(t, cpu.y, tc.expectY)
 	expect8(t, cpu.sp, tc.expectSP)
 	expect16(t, cpu.pc, tc.expectPC)
+	expect64(t, cpu.TotalCycles, tc.expectTotalCycles)
 
 	// assert flags
 	expectFlag(t, cpu, P_Carry, tc.expectCarry)
@@ -247,12 +277,29 @@ func (tc *testCase) run(t *testing.T, cpu *MOS6502) {
 	}
 	expectFlag(t, cpu, P_Reserved, true)
 
-	if tc.expectMemory != nil {
-		for address := range cpu.memory {
-			expected := tc.expectMemory[uint16(address)]
-			if cpu.memory[address] != expected {
-				t.Errorf("expected memory %04x to be %02x got %02x", address, expected, cpu.memory[address])
-			}
+	if tc.expectDelta != nil {
+		expectDelta(t, tc.initial, cpu.memory, tc.expectDelta)
+	}
+}
+
+// check the only differences between before and after are the expected
+// ones, listing an address that did not change at its original value is
+// allowed
+func expectDelta(t *testing.T, before, after *Memory, expected map[uint16]uint8) {
+	t.Helper()
+
+	for address, value := range expected {
+		if after[address] != value {
+			t.Errorf("expected memory %04x to be %02x got %02x", address, value, after[address])
+		}
+	}
+
+	for address := range after {
+		if after[address] == before[address] {
+			continue
+		}
+		if _, ok := expected[uint16(address)]; !ok {
+			t.Errorf("unexpected change to memory %04x from %02x to %02x", address, before[address], after[address])
 		}
 	}
 }
@@ -269,3 +316,814 @@ func (tcs testCases) run(t *testing.T) {
 		})
 	}
 }
+
+func TestBusy(t *testing.T) {
+	// INC $42,X takes 6 cycles
+	cpu := setup([]uint8{0xf6, 0x42, 0xea}, nil)
+
+	if cpu.Busy() {
+		t.Fatalf("expected cpu to not be busy before the first cycle")
+	}
+
+	for i := 1; i < 6; i++ {
+		cpu.Cycle()
+		if !cpu.Busy() {
+			t.Fatalf("expected cpu to be busy after cycle %d", i)
+		}
+		if remaining := cpu.RemainingCycles(); remaining != uint8(6-i) {
+			t.Errorf("expected %d remaining cycles after cycle %d got %d", 6-i, i, remaining)
+		}
+	}
+
+	cpu.Cycle()
+	if cpu.Busy() {
+		t.Errorf("expected cpu to not be busy after the sixth cycle")
+	}
+	if cpu.pc != ProgramStart+2 {
+		t.Errorf("expected pc %04x got %04x", ProgramStart+2, cpu.pc)
+	}
+
+	// the next cycle starts the NOP
+	cpu.Cycle()
+	if cpu.pc != ProgramStart+3 {
+		t.Errorf("expected pc %04x got %04x", ProgramStart+3, cpu.pc)
+	}
+}
+
+func TestSetReady(t *testing.T) {
+	// INC $42 takes 5 cycles, NOP
+	cpu := setup([]uint8{0xe6, 0x42, 0xea}, nil)
+
+	cpu.Cycle()
+	cpu.Cycle()
+	expect8(t, cpu.memory[0x42], newUint8(0x01))
+
+	cpu.SetReady(false)
+	for i := 0; i < 10; i++ {
+		cpu.Cycle()
+	}
+	if remaining := cpu.RemainingCycles(); remaining != 3 {
+		t.Errorf("expected no progress while stalled got %d remaining", remaining)
+	}
+	expect16(t, cpu.pc, newUint16(ProgramStart+2))
+	if cpu.TotalCycles != 15 {
+		t.Errorf("expected stalled cycles to be counted got %d", cpu.TotalCycles)
+	}
+
+	// resumes where it left off
+	cpu.SetReady(true)
+	for i := 0; i < 4; i++ {
+		cpu.Cycle()
+	}
+	expect16(t, cpu.pc, newUint16(ProgramStart+3))
+}
+
+func TestPause(t *testing.T) {
+	// INC $42 takes 5 cycles, NOP
+	cpu := setup([]uint8{0xe6, 0x42, 0xea}, nil)
+
+	cpu.Cycle()
+	cpu.Cycle()
+	expect8(t, cpu.memory[0x42], newUint8(0x01))
+	total := cpu.TotalCycles
+
+	cpu.Pause()
+	for i := 0; i < 10; i++ {
+		cpu.Cycle()
+	}
+	cpu.Step()
+	if halt := cpu.RunFrame(100); halt != HaltPaused {
+		t.Fatalf("expected %s got %s", HaltPaused, halt)
+	}
+	if remaining := cpu.RemainingCycles(); remaining != 3 {
+		t.Errorf("expected the wait to be kept while paused got %d remaining", remaining)
+	}
+	expect16(t, cpu.pc, newUint16(ProgramStart+2))
+	if cpu.TotalCycles != total {
+		t.Errorf("expected paused cycles to not be counted got %d", cpu.TotalCycles-total)
+	}
+	if !cpu.Paused() {
+		t.Error("expected to be paused")
+	}
+
+	// the INC finishes its 3 remaining cycles before the NOP starts
+	cpu.Resume()
+	for i := 0; i < 3; i++ {
+		cpu.Cycle()
+	}
+	expect16(t, cpu.pc, newUint16(ProgramStart+2))
+	if cpu.Busy() {
+		t.Error("expected the INC to be complete")
+	}
+	cpu.Cycle()
+	expect16(t, cpu.pc, newUint16(ProgramStart+3))
+	expect8(t, cpu.memory[0x42], newUint8(0x01))
+}
+
+func TestPauseRun(t *testing.T) {
+	// INX, RTS at $3000
+	cpu := setup([]uint8{0xea}, map[uint16]uint8{
+		0x3000: 0xe8,
+		0x3001: 0x60,
+	})
+	cpu.Pause()
+
+	// nothing runs and nothing spins waiting for the cycles to pass
+	if err := cpu.CallSubroutine(0x3000, 100); !errors.Is(err, ErrPaused) {
+		t.Errorf("expected %v got %v", ErrPaused, err)
+	}
+	if _, err := cpu.StepTrace(); !errors.Is(err, ErrPaused) {
+		t.Errorf("expected %v got %v", ErrPaused, err)
+	}
+	for _, halt := range []HaltType{
+		cpu.RunFrame(100),
+		cpu.RunInstructions(100),
+		cpu.RunContext(context.Background()),
+	} {
+		if halt != HaltPaused {
+			t.Errorf("expected %s got %s", HaltPaused, halt)
+		}
+	}
+	expect16(t, cpu.pc, newUint16(ProgramStart))
+	expect8(t, cpu.sp, newUint8(StackReset))
+	expect8(t, cpu.x, newUint8(0x00))
+	if cpu.Halt() != Continue {
+		t.Errorf("expected the cpu to not be halted got %s", cpu.Halt())
+	}
+
+	cpu.Resume()
+	if err := cpu.CallSubroutine(0x3000, 100); err != nil {
+		t.Fatal(err)
+	}
+	expect8(t, cpu.x, newUint8(0x01))
+}
+
+func TestStep(t *testing.T) {
+	// INC $42, NOP
+	cpu := setup([]uint8{0xe6, 0x42, 0xea}, nil)
+
+	cpu.Step()
+	if cpu.Busy() {
+		t.Errorf("expected cpu to not be busy after a step")
+	}
+	if cpu.memory[0x42] != 0x01 {
+		t.Errorf("expected memory 0042 to be 01 got %02x", cpu.memory[0x42])
+	}
+	if cpu.TotalCycles != 5 {
+		t.Errorf("expected 5 total cycles got %d", cpu.TotalCycles)
+	}
+
+	cpu.Step()
+	if cpu.pc != ProgramStart+3 {
+		t.Errorf("expected pc %04x got %04x", ProgramStart+3, cpu.pc)
+	}
+}
+
+func TestStepTrace(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x42, // LDA #$42
+		0x85, 0x10, // STA $10
+		0xe8, // INX
+		0x02, // unknown
+	}, nil)
+
+	for _, expected := range []string{"LDA #$42", "STA $10", "INX "} {
+		disasm, err := cpu.StepTrace()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if disasm.Disassembly != expected {
+			t.Errorf("expected %q got %q", expected, disasm.Disassembly)
+		}
+	}
+
+	expect8(t, cpu.memory[0x10], newUint8(0x42))
+	expect8(t, cpu.x, newUint8(0x01))
+
+	disasm, err := cpu.StepTrace()
+	if err == nil {
+		t.Fatal("expected an error on an unknown instruction")
+	}
+	if disasm.Opcode != OPC_BYTE {
+		t.Errorf("expected the unknown byte got %q", disasm.Disassembly)
+	}
+	if cpu.Halt() != HaltUnknownInstruction {
+		t.Errorf("expected %s got %s", HaltUnknownInstruction, cpu.Halt())
+	}
+}
+
+func TestCallSubroutine(t *testing.T) {
+	program, err := Assemble(`
+		increment:
+			JSR add
+			RTS
+		add:	CLC
+			ADC #$01
+			RTS
+		forever:
+			JMP forever
+	`, ProgramStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := setup(program.Bytes, nil)
+	cpu.a = 0x41
+	cpu.pc = 0x0400
+
+	if err := cpu.CallSubroutine(0xdd00, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	expect8(t, cpu.a, newUint8(0x42))
+	expect16(t, cpu.pc, newUint16(0x0400))
+	expect8(t, cpu.sp, newUint8(StackReset))
+
+	if err := cpu.CallSubroutine(0xdd08, 100); err == nil {
+		t.Error("expected an error from a subroutine that never returns")
+	}
+}
+
+func TestOnFetch(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa2, 0x03, // LDX #$03
+		0xca,       // DEX
+		0xd0, 0xfd, // BNE $DD02
+	}, nil)
+
+	fetches := map[uint16]int{}
+	cpu.OnFetch(func(pc uint16, opcode uint8) {
+		expect8(t, opcode, newUint8(cpu.memory[pc]))
+		fetches[pc]++
+	})
+
+	for i := 0; i < 7; i++ {
+		cpu.Step()
+	}
+
+	expected := map[uint16]int{0xdd00: 1, 0xdd02: 3, 0xdd03: 3}
+	for pc, count := range expected {
+		if fetches[pc] != count {
+			t.Errorf("expected %d fetches at %04x got %d", count, pc, fetches[pc])
+		}
+	}
+
+	// removing the hook stops the calls
+	cpu.OnFetch(nil)
+	cpu.Step()
+	if fetches[0xdd05] != 0 {
+		t.Error("expected no fetch once removed")
+	}
+}
+
+func TestProgramStart(t *testing.T) {
+	tests := testCases{
+		{
+			name:  "runs out of the zero page in to the stack page",
+			start: newUint16(0x00fe),
+			program: []uint8{
+				0xa2, 0x05, // LDX #$05
+				0xe8, // INX
+			},
+			cycles:   3,
+			expectX:  newUint8(0x06),
+			expectPC: newUint16(0x0101),
+		},
+		{
+			name:              "operand straddles a page boundary",
+			start:             newUint16(0x10fe),
+			program:           []uint8{0xad, 0x34, 0x12}, // LDA $1234
+			memory:            map[uint16]uint8{0x1234: 0x42},
+			expectA:           newUint8(0x42),
+			expectPC:          newUint16(0x1101),
+			expectTotalCycles: newUint64(4),
+		},
+		{
+			name:  "taken branch across a page boundary",
+			start: newUint16(0x10fc),
+			program: []uint8{
+				0xd0, 0x02, // BNE +2
+			},
+			expectPC:          newUint16(0x1100),
+			expectTotalCycles: newUint64(4),
+		},
+		{
+			name:           "stores alongside itself in the zero page",
+			start:          newUint16(0x0010),
+			program:        []uint8{0xa9, 0x99, 0x85, 0x14}, // LDA #$99, STA $14
+			cycles:         3,
+			expectA:        newUint8(0x99),
+			expectNegative: true,
+			expectDelta: map[uint16]uint8{
+				0x0014: 0x99,
+			},
+		},
+	}
+	tests.run(t)
+}
+
+func TestRunFrame(t *testing.T) {
+	cpu := setup([]uint8{
+		0xe8,             // INX
+		0x4c, 0x00, 0xdd, // JMP $dd00
+	}, nil)
+
+	// 5 cycles per loop so frames end part way through an instruction
+	for frame := uint64(1); frame <= 3; frame++ {
+		if halt := cpu.RunFrame(101); halt != Continue {
+			t.Fatalf("expected to continue got %s", halt)
+		}
+		if cpu.Busy() {
+			t.Fatal("expected the frame to end on an instruction boundary")
+		}
+		if cpu.TotalCycles < frame*101 {
+			t.Errorf("expected at least %d cycles got %d", frame*101, cpu.TotalCycles)
+		}
+	}
+
+	// halts end the frame early
+	cpu = setup([]uint8{0xe8, 0x02}, nil)
+	cpu.Quiet = true
+	if halt := cpu.RunFrame(100); halt != HaltUnknownInstruction {
+		t.Errorf("expected %s got %s", HaltUnknownInstruction, halt)
+	}
+	expect64(t, cpu.TotalCycles, newUint64(2))
+}
+
+func TestRunContext(t *testing.T) {
+	// JMP * forever
+	cpu := setup([]uint8{0x4c, 0x00, 0xdd}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if halt := cpu.RunContext(ctx); halt != HaltCancelled {
+		t.Errorf("expected %s got %s", HaltCancelled, halt)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if halt := cpu.RunContext(ctx); halt != HaltCancelled {
+		t.Errorf("expected %s got %s", HaltCancelled, halt)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to return promptly took %s", elapsed)
+	}
+	if cpu.Halt() != Continue {
+		t.Errorf("expected the cpu to be able to run again got %s", cpu.Halt())
+	}
+
+	// a halt returns before the context is done
+	cpu = setup([]uint8{0x02}, nil)
+	cpu.Quiet = true
+	if halt := cpu.RunContext(context.Background()); halt != HaltUnknownInstruction {
+		t.Errorf("expected %s got %s", HaltUnknownInstruction, halt)
+	}
+}
+
+func TestRunInstructions(t *testing.T) {
+	program := []uint8{
+		0xa9, 0x42, // LDA #$42
+		0x48, // PHA
+		0xaa, // TAX
+		0xe8, // INX
+		0x02, // unknown
+	}
+
+	stepped := setup(program, nil)
+	for i := 0; i < 3; i++ {
+		stepped.Step()
+	}
+
+	cpu := setup(program, nil)
+	if halt := cpu.RunInstructions(3); halt != Continue {
+		t.Fatalf("expected to continue got %s", halt)
+	}
+	if cpu.Registers() != stepped.Registers() {
+		t.Errorf("expected %s got %s", stepped.Registers(), cpu.Registers())
+	}
+	expect64(t, cpu.TotalCycles, &stepped.TotalCycles)
+	expect8(t, cpu.memory[0x01fd], newUint8(0x42))
+
+	// stops at the halt rather than running all 10
+	cpu.Quiet = true
+	if halt := cpu.RunInstructions(10); halt != HaltUnknownInstruction {
+		t.Errorf("expected %s got %s", HaltUnknownInstruction, halt)
+	}
+	expect8(t, cpu.x, newUint8(0x43))
+	expect16(t, cpu.pc, newUint16(ProgramStart+5))
+}
+
+func TestStackPointerAddress(t *testing.T) {
+	cpu := setup([]uint8{
+		0x48, // PHA
+		0x48, // PHA
+		0x08, // PHP
+	}, nil)
+
+	expect16(t, cpu.StackPointerAddress(), newUint16(0x01fd))
+
+	for i := 0; i < 3; i++ {
+		cpu.Step()
+	}
+
+	expect16(t, cpu.StackPointerAddress(), newUint16(0x01fa))
+	expect16(t, cpu.StackPointerAddress(), newUint16(stackAddress(cpu.sp)))
+}
+
+func TestStackAddress(t *testing.T) {
+	expect16(t, stackAddress(StackTop), newUint16(0x01ff))
+	expect16(t, stackAddress(StackBottom), newUint16(0x0100))
+
+	// pushes walk down the page one byte at a time and wrap from the
+	// bottom back to the top without leaving page 1
+	cpu := setup(nil, nil)
+	cpu.sp = StackTop
+	for i := 0; i < 0x100; i++ {
+		expect16(t, cpu.StackPointerAddress(), newUint16(0x01ff-uint16(i)))
+		cpu.push(uint8(i))
+	}
+	expect8(t, cpu.sp, newUint8(StackTop))
+	expect8(t, cpu.memory[0x0100], newUint8(0xff))
+
+	// the next push overwrites the first
+	cpu.push(0xaa)
+	expect8(t, cpu.sp, newUint8(StackTop-1))
+	expect8(t, cpu.memory[0x01ff], newUint8(0xaa))
+	expect8(t, cpu.memory[0x0200], newUint8(0x00))
+
+	// and pops wrap back up from the top to the bottom
+	cpu.sp = StackTop
+	expect8(t, cpu.pop(), newUint8(0xff))
+	expect8(t, cpu.sp, newUint8(StackBottom))
+}
+
+func TestSelfModifyingCode(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0xe8, // LDA #$E8 (INX)
+		0x8d, 0x06, 0xdd, // STA $DD06
+		0xea, // NOP
+		0xc8, // INY, replaced by INX before it is fetched
+	}, nil)
+
+	for i := 0; i < 4; i++ {
+		cpu.Step()
+	}
+
+	expect8(t, cpu.x, newUint8(0x01))
+	expect8(t, cpu.y, newUint8(0x00))
+	expect16(t, cpu.pc, newUint16(ProgramStart+7))
+	if d := cpu.Disassemble(ProgramStart + 6); d.Opcode != OPC_INX {
+		t.Errorf("expected INX got %s", d.Opcode)
+	}
+}
+
+func TestGuardVectorExecution(t *testing.T) {
+	// JMP $FFFA
+	cpu := setup([]uint8{0x4c, 0xfa, 0xff}, map[uint16]uint8{
+		// NOP so it would otherwise run
+		NMIVectorLow: 0xea,
+	})
+	cpu.GuardVectorExecution = true
+	cpu.Quiet = true
+
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(NMIVectorLow))
+	if cpu.Halt() != Continue {
+		t.Fatalf("expected the jump to run got %s", cpu.Halt())
+	}
+
+	cpu.Step()
+	if cpu.Halt() != HaltExecutingVectors {
+		t.Errorf("expected %s got %s", HaltExecutingVectors, cpu.Halt())
+	}
+	expect16(t, cpu.pc, newUint16(NMIVectorLow))
+
+	// off by default
+	cpu = setup([]uint8{0x4c, 0xfa, 0xff}, map[uint16]uint8{NMIVectorLow: 0xea})
+	cpu.Step()
+	cpu.Step()
+	if cpu.Halt() != Continue {
+		t.Errorf("expected no guard by default got %s", cpu.Halt())
+	}
+}
+
+func TestProtectROM(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x42, // LDA #$42
+		0x8d, 0x00, 0x10, // STA $1000
+		0x8d, 0x00, 0x90, // STA $9000
+	}, map[uint16]uint8{0x9000: 0x99})
+	cpu.ProtectROM = true
+	cpu.ROMStart = 0x8000
+
+	var ignored []uint16
+	cpu.OnROMWrite = func(address uint16, value uint8) {
+		expect8(t, value, newUint8(0x42))
+		ignored = append(ignored, address)
+	}
+
+	for i := 0; i < 3; i++ {
+		cpu.Step()
+	}
+
+	expect8(t, cpu.memory[0x1000], newUint8(0x42))
+	expect8(t, cpu.memory[0x9000], newUint8(0x99))
+	if len(ignored) != 1 || ignored[0] != 0x9000 {
+		t.Errorf("expected one ignored write to $9000 got %04x", ignored)
+	}
+}
+
+func TestZeroPage(t *testing.T) {
+	cpu := setup([]uint8{0x85, 0xff}, map[uint16]uint8{0x0000: 0x01, 0x0100: 0x02})
+	cpu.a = 0x42
+	cpu.Step()
+
+	page := cpu.ZeroPage()
+	expect8(t, page[0x00], newUint8(0x01))
+	expect8(t, page[0xff], newUint8(0x42))
+
+	// it is a copy
+	page[0x00] = 0x10
+	expect8(t, cpu.memory[0x00], newUint8(0x01))
+}
+
+func TestStack(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x01, // LDA #$01
+		0x48,       // PHA
+		0xa9, 0x02, // LDA #$02
+		0x48, // PHA
+		0x68, // PLA
+	}, nil)
+	// as a program would with TXS, reset leaves two bytes above sp
+	cpu.sp = StackTop
+
+	if stack := cpu.Stack(); len(stack) != 0 {
+		t.Fatalf("expected an empty stack got % x", stack)
+	}
+
+	for i := 0; i < 4; i++ {
+		cpu.Step()
+	}
+
+	// most recent push first
+	if stack := cpu.Stack(); !bytes.Equal(stack, []uint8{0x02, 0x01}) {
+		t.Errorf("expected 02 01 got % x", stack)
+	}
+
+	cpu.Step()
+
+	if stack := cpu.Stack(); !bytes.Equal(stack, []uint8{0x01}) {
+		t.Errorf("expected 01 got % x", stack)
+	}
+}
+
+func TestWrapTopOfMemory(t *testing.T) {
+	// LDA #$42 at $fffe leaves the pc at $0000
+	cpu := setup(nil, map[uint16]uint8{0xfffe: 0xa9, 0xffff: 0x42})
+	cpu.SetPC(0xfffe)
+	cpu.Step()
+
+	expect8(t, cpu.a, newUint8(0x42))
+	expect16(t, cpu.pc, newUint16(0x0000))
+
+	// LDA $1234 at $ffff takes its operand from $0000 and $0001
+	cpu = setup(nil, map[uint16]uint8{
+		0xffff: 0xad,
+		0x0000: 0x34,
+		0x0001: 0x12,
+		0x1234: 0x99,
+	})
+	cpu.SetPC(0xffff)
+	cpu.Step()
+
+	expect8(t, cpu.a, newUint8(0x99))
+	expect16(t, cpu.pc, newUint16(0x0001+1))
+	if cpu.Halt() != Continue {
+		t.Errorf("expected cpu to continue got %s", cpu.Halt())
+	}
+
+	// words read at the top of memory wrap to $0000
+	expect16(t, cpu.memory.ReadWord(0xffff), newUint16(0x34ad))
+	expect16(t, cpu.readWord(0xffff), newUint16(0x34ad))
+}
+
+func TestQuiet(t *testing.T) {
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	defer log.SetOutput(os.Stderr)
+
+	cpu := setup([]uint8{0x02}, nil)
+	cpu.Step()
+
+	if !strings.Contains(out.String(), "no instruction found for opcode 02 at dd00") {
+		t.Errorf("expected unknown opcode to be logged got %q", out.String())
+	}
+
+	out.Reset()
+
+	cpu = setup([]uint8{0x02}, nil)
+	cpu.Quiet = true
+	cpu.Step()
+
+	if cpu.Halt() != HaltUnknownInstruction {
+		t.Errorf("expected %s got %s", HaltUnknownInstruction, cpu.Halt())
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no log output got %q", out.String())
+	}
+}
+
+func TestCheckResetVector(t *testing.T) {
+	cpu := setup([]uint8{0xea}, nil)
+	if err := cpu.CheckResetVector(); err != nil {
+		t.Errorf("expected a NOP to be fine got %s", err)
+	}
+
+	// erased ROM reads back $ff
+	memory := NewMemory()
+	for i := range memory {
+		memory[i] = 0xff
+	}
+	cpu.Reset(memory)
+	if err := cpu.CheckResetVector(); !errors.Is(err, ErrBadResetVector) {
+		t.Errorf("expected %s got %v", ErrBadResetVector, err)
+	}
+}
+
+func TestPC(t *testing.T) {
+	// NOP
+	cpu := setup([]uint8{0xea}, map[uint16]uint8{0x1234: 0xea})
+	expect16(t, cpu.PC(), newUint16(ProgramStart))
+
+	cpu.Step()
+	expect16(t, cpu.PC(), newUint16(ProgramStart+1))
+
+	// a monitor's jump
+	cpu.SetPC(0x1234)
+	expect16(t, cpu.PC(), newUint16(0x1234))
+	cpu.Step()
+	expect16(t, cpu.PC(), newUint16(0x1235))
+}
+
+func TestReset(t *testing.T) {
+	cpu := setup([]uint8{0xea}, nil)
+
+	expect8(t, cpu.a, newUint8(0x00))
+	expect8(t, cpu.x, newUint8(0x00))
+	expect8(t, cpu.y, newUint8(0x00))
+	// the suppressed pushes of the reset sequence leave sp at $fd
+	expect8(t, cpu.sp, newUint8(0xfd))
+	expect16(t, cpu.pc, newUint16(ProgramStart))
+	expectFlag(t, cpu, P_InterruptDisable, true)
+
+	// power on values are used on the next reset
+	cpu.PowerOnA = 0xaa
+	cpu.PowerOnX = 0x01
+	cpu.PowerOnY = 0x02
+	cpu.Reset(cpu.memory)
+
+	expect8(t, cpu.a, newUint8(0xaa))
+	expect8(t, cpu.x, newUint8(0x01))
+	expect8(t, cpu.y, newUint8(0x02))
+}
+
+func TestResetStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant Variant
+		decimal bool
+		expect  flags
+	}{
+		{"nmos", NMOS6502, false, 0b00110100},
+		{"nmos decimal kept", NMOS6502, true, 0b00111100},
+		{"cmos", CMOS65C02, false, 0b00110100},
+		{"cmos decimal cleared", CMOS65C02, true, 0b00110100},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := setup([]uint8{0xea}, nil)
+			cpu.SetVariant(tc.variant)
+
+			cpu.p = 0xff
+			cpu.p.set(P_Decimal, tc.decimal)
+			cpu.Reset(cpu.memory)
+
+			if cpu.p != tc.expect {
+				t.Errorf("expected p=%08b got %08b", tc.expect, cpu.p)
+			}
+		})
+	}
+}
+
+// run a copy loop that touches the common addressing modes
+func BenchmarkCycle(b *testing.B) {
+	program, err := Assemble(`
+		start:	LDX #$00
+		loop:	LDA $1000,X
+			ADC ($20),Y
+			STA $2000,X
+			ROL $30
+			INX
+			BNE loop
+			JSR sub
+			JMP start
+		sub:	RTS
+	`, ProgramStart)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	cpu := setup(program.Bytes, map[uint16]uint8{0x20: 0x00, 0x21: 0x30})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		cpu.Cycle()
+	}
+}
+
+func TestStepBack(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x01, // LDA #$01
+		0x85, 0x10, // STA $10
+		0xe6, 0x10, // INC $10
+		0x48,       // PHA
+		0xe6, 0x10, // INC $10
+	}, nil)
+	cpu.EnableHistory(4)
+
+	var registers []Registers
+	var values []uint8
+	for i := 0; i < 5; i++ {
+		registers = append(registers, cpu.Registers())
+		values = append(values, cpu.memory[0x10])
+		cpu.Step()
+	}
+	expect8(t, cpu.memory[0x10], newUint8(0x03))
+
+	for i := 4; i >= 3; i-- {
+		if err := cpu.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+		if cpu.Registers() != registers[i] {
+			t.Errorf("expected %s got %s", registers[i], cpu.Registers())
+		}
+		expect8(t, cpu.memory[0x10], &values[i])
+	}
+	// the push is undone as well as the stack pointer
+	expect8(t, cpu.memory[0x01fd], newUint8(0x00))
+	expect64(t, cpu.TotalCycles, newUint64(2+3+5))
+
+	// stepping forward again replays the same instructions
+	cpu.Step()
+	cpu.Step()
+	expect8(t, cpu.memory[0x10], newUint8(0x03))
+	expect8(t, cpu.memory[0x01fd], newUint8(0x01))
+
+	// only depth instructions are kept
+	for i := 0; i < 4; i++ {
+		if err := cpu.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cpu.StepBack(); err == nil {
+		t.Error("expected an error once history runs out")
+	}
+	expect16(t, cpu.pc, newUint16(ProgramStart+2))
+}
+
+func TestStepBackDevices(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x01, // LDA #$01
+		0x85, 0x10, // STA $10
+		0x8d, 0x10, 0xd0, // STA $D010
+		0xad, 0x10, 0xd0, // LDA $D010
+	}, nil)
+
+	keys := make(chan uint8, 2)
+	keys <- 'A'
+	keys <- 'B'
+	cpu.SetBus(NewKeyboard(cpu.memory, keys))
+	cpu.EnableHistory(4)
+
+	// recording the write to the data register must not take a key
+	for i := 0; i < 4; i++ {
+		cpu.Step()
+	}
+	expect8(t, cpu.a, newUint8('A'))
+
+	// memory behind the keyboard is still undone
+	for i := 0; i < 3; i++ {
+		if err := cpu.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect8(t, cpu.memory[0x10], newUint8(0x00))
+	expect8(t, cpu.memory[KeyboardData], newUint8(0x00))
+	expect16(t, cpu.pc, newUint16(ProgramStart+2))
+}
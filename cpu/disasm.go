@@ -4,26 +4,48 @@ import (
 	"fmt"
 )
 
+// OPC_BYTE is the Opcode of a byte that is not an instruction, shown as
+// data so disassembly can carry on from the next byte
+const OPC_BYTE OPCode = ".BYTE"
+
 type DisassembledInstruction struct {
-	Address     uint16
-	Opcode      OPCode
+	Address uint16
+	Opcode  OPCode
+	// number of bytes to the next instruction
+	Size uint8
+	// the raw operand bytes, a single byte for zeropage, immediate and
+	// branch offsets
 	Operand     uint16
 	Mode        AddressMode
 	Disassembly string
+	// where a JMP, JSR or branch goes, resolving indirect jumps through
+	// memory the same way executing them would. 0 for other instructions
+	Target uint16
 }
 
-func (cpu *MOS6502) disassembleInstruction(address uint16) *DisassembledInstruction {
+// Disassemble the instruction at address, any operand found in Symbols is
+// shown by name. unknown opcodes come back as a single .BYTE
+func (cpu *MOS6502) Disassemble(address uint16) *DisassembledInstruction {
 	opcode := cpu.memory.Read(address)
 	instruction := cpu.instructions[opcode]
 
 	if instruction == nil {
-		return nil
+		return &DisassembledInstruction{
+			Address:     address,
+			Opcode:      OPC_BYTE,
+			Size:        1,
+			Operand:     uint16(opcode),
+			Disassembly: fmt.Sprintf("%s $%02X", OPC_BYTE, opcode),
+		}
 	}
 
-	var operand uint16
+	var operand, target uint16
 	var disassembly string
 
-	if instruction.size > 1 {
+	switch instruction.size {
+	case 2:
+		operand = uint16(cpu.memory.Read(address + 1))
+	case 3:
 		operand = cpu.memory.ReadWord(address + 1)
 	}
 
@@ -36,32 +58,52 @@ func (cpu *MOS6502) disassembleInstruction(address uint16) *DisassembledInstruct
 	case AM_IMMEDIATE:
 		disassembly += fmt.Sprintf("#$%02X", operand&0xFF)
 	case AM_ABSOLUTE:
-		disassembly += fmt.Sprintf("$%04X", operand)
+		disassembly += cpu.symbol(operand, "$%04X")
+		if instruction.opc == OPC_JMP || instruction.opc == OPC_JSR {
+			target = operand
+		}
 	case AM_ZEROPAGE:
-		disassembly += fmt.Sprintf("$%02X", operand&0xFF)
+		disassembly += cpu.symbol(operand&0xFF, "$%02X")
 	case AM_ABSOLUTE_X:
-		disassembly += fmt.Sprintf("$%04X,X", operand)
+		disassembly += cpu.symbol(operand, "$%04X") + ",X"
 	case AM_ABSOLUTE_Y:
-		disassembly += fmt.Sprintf("$%04X,Y", operand)
+		disassembly += cpu.symbol(operand, "$%04X") + ",Y"
 	case AM_ZEROPAGE_X:
-		disassembly += fmt.Sprintf("$%02X,X", operand&0xFF)
+		disassembly += cpu.symbol(operand&0xFF, "$%02X") + ",X"
 	case AM_ZEROPAGE_Y:
-		disassembly += fmt.Sprintf("$%02X,Y", operand&0xFF)
+		disassembly += cpu.symbol(operand&0xFF, "$%02X") + ",Y"
 	case AM_INDIRECT:
-		disassembly += fmt.Sprintf("($%04X)", operand)
+		disassembly += "(" + cpu.symbol(operand, "$%04X") + ")"
+		target = Word(cpu.memory.Read(operand), cpu.memory.Read(cpu.indirectHigh(operand)))
+	case AM_ABSOLUTE_X_INDIRECT:
+		disassembly += "(" + cpu.symbol(operand, "$%04X") + ",X)"
 	case AM_INDIRECT_X:
-		disassembly += fmt.Sprintf("($%02X,X)", operand&0xFF)
+		disassembly += "(" + cpu.symbol(operand&0xFF, "$%02X") + ",X)"
 	case AM_INDIRECT_Y:
-		disassembly += fmt.Sprintf("($%02X),Y", operand&0xFF)
+		disassembly += "(" + cpu.symbol(operand&0xFF, "$%02X") + "),Y"
 	case AM_RELATIVE:
-		disassembly += fmt.Sprintf("$%04X", address+2+uint16(int8(operand&0xFF)))
+		target = address + 2 + uint16(int8(operand&0xFF))
+		disassembly += cpu.symbol(target, "$%04X")
+		if cpu.ShowBranchOffsets {
+			disassembly += fmt.Sprintf(" ; $%02X", operand&0xFF)
+		}
 	}
 
 	return &DisassembledInstruction{
 		Address:     address,
 		Opcode:      instruction.opc,
+		Size:        instruction.size,
 		Operand:     operand,
 		Mode:        instruction.mode,
 		Disassembly: disassembly,
+		Target:      target,
+	}
+}
+
+// name an address from the symbol table, falling back to format
+func (cpu *MOS6502) symbol(address uint16, format string) string {
+	if name, ok := cpu.Symbols[address]; ok {
+		return name
 	}
+	return fmt.Sprintf(format, address)
 }
@@ -0,0 +1,81 @@
+package cpu
+
+import (
+	"image"
+	"image/color"
+)
+
+// easy6502 style display, a 32x32 grid of pixels at $0200-$05ff
+const (
+	DisplayBase   uint16 = 0x0200
+	DisplayWidth         = 32
+	DisplayHeight        = 32
+)
+
+// the 16 colours of the easy6502 display, only the low nibble of a pixel
+// is used to pick one
+var DisplayPalette = color.Palette{
+	color.RGBA{0x00, 0x00, 0x00, 0xff}, // black
+	color.RGBA{0xff, 0xff, 0xff, 0xff}, // white
+	color.RGBA{0x88, 0x00, 0x00, 0xff}, // red
+	color.RGBA{0xaa, 0xff, 0xee, 0xff}, // cyan
+	color.RGBA{0xcc, 0x44, 0xcc, 0xff}, // purple
+	color.RGBA{0x00, 0xcc, 0x55, 0xff}, // green
+	color.RGBA{0x00, 0x00, 0xaa, 0xff}, // blue
+	color.RGBA{0xee, 0xee, 0x77, 0xff}, // yellow
+	color.RGBA{0xdd, 0x88, 0x55, 0xff}, // orange
+	color.RGBA{0x66, 0x44, 0x00, 0xff}, // brown
+	color.RGBA{0xff, 0x77, 0x77, 0xff}, // light red
+	color.RGBA{0x33, 0x33, 0x33, 0xff}, // dark grey
+	color.RGBA{0x77, 0x77, 0x77, 0xff}, // grey
+	color.RGBA{0xaa, 0xff, 0x66, 0xff}, // light green
+	color.RGBA{0x00, 0x88, 0xff, 0xff}, // light blue
+	color.RGBA{0xbb, 0xbb, 0xbb, 0xff}, // light grey
+}
+
+// Display is a memory mapped bitmap with one byte per pixel laid out a row
+// at a time from base. Writes to the display are also passed to the
+// underlying bus so reads see what was drawn
+type Display struct {
+	bus  Bus
+	base uint16
+
+	pixels [DisplayWidth * DisplayHeight]uint8
+}
+
+func NewDisplay(bus Bus, base uint16) *Display {
+	return &Display{
+		bus:  bus,
+		base: base,
+	}
+}
+
+// offset of the pixel at address, false if it is not on the display
+func (d *Display) pixel(address uint16) (int, bool) {
+	offset := int(address) - int(d.base)
+	return offset, offset >= 0 && offset < len(d.pixels)
+}
+
+func (d *Display) Read(address uint16) uint8 {
+	return d.bus.Read(address)
+}
+
+// the pixels are only written so reading is always quiet
+func (d *Display) Peek(address uint16) (uint8, bool) {
+	return peekBus(d.bus, address)
+}
+
+func (d *Display) Write(address uint16, value uint8) {
+	if offset, ok := d.pixel(address); ok {
+		d.pixels[offset] = value & 0x0f
+	}
+
+	d.bus.Write(address, value)
+}
+
+// Snapshot renders the display as it is now
+func (d *Display) Snapshot() image.Image {
+	img := image.NewPaletted(image.Rect(0, 0, DisplayWidth, DisplayHeight), DisplayPalette)
+	copy(img.Pix, d.pixels[:])
+	return img
+}
@@ -0,0 +1,52 @@
+package cpu
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestDisplay(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x01, // LDA #$01
+		0x8d, 0x00, 0x02, // STA $0200
+		0xa9, 0x05, // LDA #$05
+		0x8d, 0x21, 0x02, // STA $0221
+		0xa9, 0xf2, // LDA #$f2
+		0x8d, 0xff, 0x05, // STA $05ff
+		0x8d, 0x00, 0x06, // STA $0600
+	}, nil)
+
+	display := NewDisplay(cpu.memory, DisplayBase)
+	cpu.SetBus(display)
+
+	for i := 0; i < 7; i++ {
+		cpu.Step()
+	}
+
+	img := display.Snapshot()
+
+	if img.Bounds().Dx() != DisplayWidth || img.Bounds().Dy() != DisplayHeight {
+		t.Fatalf("expected %dx%d got %s", DisplayWidth, DisplayHeight, img.Bounds())
+	}
+
+	pixels := []struct {
+		x, y  int
+		color color.Color
+	}{
+		{0, 0, DisplayPalette[0x01]},
+		{1, 1, DisplayPalette[0x05]},
+		// only the low nibble picks the colour
+		{31, 31, DisplayPalette[0x02]},
+		{1, 0, DisplayPalette[0x00]},
+	}
+
+	for _, p := range pixels {
+		if img.At(p.x, p.y) != p.color {
+			t.Errorf("expected %v at %d,%d got %v", p.color, p.x, p.y, img.At(p.x, p.y))
+		}
+	}
+
+	// writes still reach memory, including outside the display
+	expect8(t, cpu.memory[0x0200], newUint8(0x01))
+	expect8(t, cpu.memory[0x0600], newUint8(0xf2))
+}
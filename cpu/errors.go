@@ -0,0 +1,18 @@
+package cpu
+
+import (
+	"errors"
+)
+
+// errors returned when loading memory, resetting or running, check for them with
+// errors.Is as they are wrapped with the details
+var (
+	// the image does not fit in 64k at the address it is loaded to
+	ErrROMTooLarge = errors.New("ROM too large")
+	// an Intel HEX or S-record line could not be parsed
+	ErrBadRecord = errors.New("bad record")
+	// the reset vector does not point at an instruction
+	ErrBadResetVector = errors.New("bad reset vector")
+	// the cpu was asked to run while paused
+	ErrPaused = errors.New("cpu is paused")
+)
@@ -60,6 +60,13 @@ func (a *flags) String() string {
 	return b.String()
 }
 
+// SetNZ sets the negative and zero flags from a result value, the most
+// common flag update shared by nearly every instruction
+func (cpu *MOS6502) SetNZ(value uint8) {
+	cpu.testAndSetNegative(value)
+	cpu.testAndSetZero(value)
+}
+
 func (cpu *MOS6502) testAndSetNegative(b uint8) {
 	cpu.p.set(P_Negative, b&0x80 == 0x80)
 }
@@ -68,11 +75,6 @@ func (cpu *MOS6502) testAndSetZero(b uint8) {
 	cpu.p.set(P_Zero, b == 0x0)
 }
 
-func (cpu *MOS6502) testAndSetCarry(b uint16) {
-	// if b is bigger than an 8bit set the carry
-	cpu.p.set(P_Carry, b > 0xff)
-}
-
 func (cpu *MOS6502) testAndSetOverflow(a, b, sum uint8) {
 	// Calculate the overflow by checking if the sign bit of the operands and
 	// the result differ (which indicates a signed overflow has occurred).
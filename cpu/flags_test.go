@@ -12,3 +12,19 @@ func expectFlag(t *testing.T, cpu *MOS6502, f flag, expect bool) {
 		t.Errorf("expected p=%08b expected: %t got: %t", f, expect, cpu.p.isSet(f))
 	}
 }
+
+func TestSetNZ(t *testing.T) {
+	cpu := NewMOS6502()
+
+	cpu.SetNZ(0x00)
+	expectFlag(t, cpu, P_Zero, true)
+	expectFlag(t, cpu, P_Negative, false)
+
+	cpu.SetNZ(0x80)
+	expectFlag(t, cpu, P_Zero, false)
+	expectFlag(t, cpu, P_Negative, true)
+
+	cpu.SetNZ(0x01)
+	expectFlag(t, cpu, P_Zero, false)
+	expectFlag(t, cpu, P_Negative, false)
+}
@@ -0,0 +1,159 @@
+package cpu
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LoadHex places the data records of an Intel HEX file in to memory at the
+// addresses they give, stopping at the end of file record
+func LoadHex(r io.Reader, m *Memory) error {
+	return loadRecords(r, func(line string) (bool, error) {
+		if !strings.HasPrefix(line, ":") {
+			return false, fmt.Errorf("record does not start with ':'")
+		}
+
+		// :LLAAAATT<data>CC
+		b, err := decodeRecord(line[1:], 0x00)
+		if err != nil {
+			return false, err
+		}
+		if len(b) < 5 || int(b[0]) != len(b)-5 {
+			return false, fmt.Errorf("record length does not match its data")
+		}
+
+		address := uint16(b[1])<<8 | uint16(b[2])
+		data := b[4 : len(b)-1]
+
+		switch b[3] {
+		case 0x00:
+			return false, place(m, uint32(address), data)
+		case 0x01:
+			return true, nil
+		case 0x02, 0x04:
+			// segment and linear addresses move past 64k
+			for _, v := range data {
+				if v != 0 {
+					return false, fmt.Errorf("extended address beyond 64k")
+				}
+			}
+			return false, nil
+		case 0x03, 0x05:
+			// start addresses, the reset vector is used instead
+			return false, nil
+		}
+
+		return false, fmt.Errorf("unknown record type %02x", b[3])
+	})
+}
+
+// LoadSRec places the data records of a Motorola S-record file in to memory
+// at the addresses they give, stopping at the termination record
+func LoadSRec(r io.Reader, m *Memory) error {
+	return loadRecords(r, func(line string) (bool, error) {
+		if len(line) < 2 || line[0] != 'S' {
+			return false, fmt.Errorf("record does not start with 'S'")
+		}
+
+		// STCCAAAA<data>CC with 2, 3 or 4 address bytes depending on type
+		b, err := decodeRecord(line[2:], 0xff)
+		if err != nil {
+			return false, err
+		}
+		if len(b) < 1 || int(b[0]) != len(b)-1 {
+			return false, fmt.Errorf("record length does not match its data")
+		}
+
+		var size int
+		switch line[1] {
+		case '0', '5', '6':
+			// header and record counts
+			return false, nil
+		case '1':
+			size = 2
+		case '2':
+			size = 3
+		case '3':
+			size = 4
+		case '7', '8', '9':
+			return true, nil
+		default:
+			return false, fmt.Errorf("unknown record type S%c", line[1])
+		}
+
+		if len(b) < size+2 {
+			return false, fmt.Errorf("record too short for its address")
+		}
+
+		var address uint32
+		for _, v := range b[1 : 1+size] {
+			address = address<<8 | uint32(v)
+		}
+
+		return false, place(m, address, b[1+size:len(b)-1])
+	})
+}
+
+// call parse with each non blank line until it reports the end, errors are
+// given the line number they were found on
+func loadRecords(r io.Reader, parse func(line string) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		end, err := parse(line)
+		if errors.Is(err, ErrROMTooLarge) {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: line %d: %s", ErrBadRecord, n, err)
+		}
+		if end {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: missing end of file record", ErrBadRecord)
+}
+
+// decode the hex of a record checking its bytes including the checksum add
+// up to sum, $00 for Intel HEX and $ff for S-records
+func decodeRecord(s string, sum uint8) ([]uint8, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty record")
+	}
+
+	var total uint8
+	for _, v := range b {
+		total += v
+	}
+	if total != sum {
+		return nil, fmt.Errorf("bad checksum %02x", b[len(b)-1])
+	}
+
+	return b, nil
+}
+
+// copy data in to memory at address refusing anything past 64k
+func place(m *Memory, address uint32, data []uint8) error {
+	if int(address)+len(data) > len(m) {
+		return fmt.Errorf("%w: data at %x runs past the end of memory", ErrROMTooLarge, address)
+	}
+	copy(m[address:], data)
+	return nil
+}
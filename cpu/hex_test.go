@@ -0,0 +1,85 @@
+package cpu
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLoadHex(t *testing.T) {
+	memory := NewMemory()
+	err := LoadHex(strings.NewReader(`
+:05040000A9428D00027D
+:02FFFC000004FF
+:00000001FF
+`), memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[uint16]uint8{
+		0x0400: 0xa9, 0x0401: 0x42, 0x0402: 0x8d, 0x0403: 0x00, 0x0404: 0x02,
+		RESVectorLow: 0x00, RESVectorHigh: 0x04,
+	}
+	expectDelta(t, NewMemory(), memory, expected)
+
+	// and it runs
+	cpu := NewMOS6502()
+	cpu.Reset(memory)
+	cpu.Step()
+	cpu.Step()
+	expect8(t, memory[0x0200], newUint8(0x42))
+}
+
+func TestLoadSRec(t *testing.T) {
+	memory := NewMemory()
+	err := LoadSRec(strings.NewReader(`
+S00600004844521B
+S1080400A9428D000279
+S20600FFFC0004FA
+S9030400F8
+`), memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[uint16]uint8{
+		0x0400: 0xa9, 0x0401: 0x42, 0x0402: 0x8d, 0x0403: 0x00, 0x0404: 0x02,
+		RESVectorLow: 0x00, RESVectorHigh: 0x04,
+	}
+	expectDelta(t, NewMemory(), memory, expected)
+}
+
+func TestLoadRecordErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		err error
+	}{
+		// bad checksum
+		{":05040000A9428D00027E\n:00000001FF", ErrBadRecord},
+		// length does not match
+		{":06040000A9428D00027C\n:00000001FF", ErrBadRecord},
+		// past the end of memory
+		{":02FFFF000004FC\n:00000001FF", ErrROMTooLarge},
+		// above 64k
+		{":020000040001F9\n:00000001FF", ErrBadRecord},
+		// no end of file
+		{":05040000A9428D00027D", ErrBadRecord},
+		{"05040000A9428D00027D", ErrBadRecord},
+	} {
+		if err := LoadHex(strings.NewReader(tc.src), NewMemory()); !errors.Is(err, tc.err) {
+			t.Errorf("expected %s loading hex %q got %v", tc.err, tc.src, err)
+		}
+	}
+
+	for _, src := range []string{
+		"S1080400A9428D000278\nS9030400F8",
+		"S1080400A9428D0002\nS9030400F8",
+		"S4030400F8",
+		"S1080400A9428D000279",
+	} {
+		if err := LoadSRec(strings.NewReader(src), NewMemory()); !errors.Is(err, ErrBadRecord) {
+			t.Errorf("expected %s loading s-record %q got %v", ErrBadRecord, src, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package cpu
+
+import (
+	"errors"
+)
+
+// memory write that can be undone by writing value back to address
+type undo struct {
+	address uint16
+	value   uint8
+}
+
+// state before an instruction along with the writes it made, so stepping
+// back only has to undo the bytes that changed rather than copy 64k
+type snapshot struct {
+	a, x, y, sp uint8
+	pc          uint16
+	p           flags
+	wait        uint8
+	current     *instruction
+	halt        HaltType
+	irq, nmi    bool
+	totalCycles uint64
+	writes      []undo
+}
+
+// ring buffer of the most recent snapshots
+type history struct {
+	snapshots []snapshot
+	// next snapshot to record in to
+	head int
+	// number of snapshots that can be stepped back through
+	size int
+}
+
+// EnableHistory records the state before each of the last depth
+// instructions so they can be undone with StepBack. a depth of 0 disables
+// history. writes are undone through the bus so devices will see them, a
+// bus must implement Peeker for its writes to be recorded and writes to
+// device registers are never undone
+func (cpu *MOS6502) EnableHistory(depth int) {
+	if depth <= 0 {
+		cpu.history = nil
+		return
+	}
+	cpu.history = &history{
+		snapshots: make([]snapshot, depth),
+	}
+}
+
+// StepBack restores the cpu and memory to how they were before the last
+// instruction or interrupt
+func (cpu *MOS6502) StepBack() error {
+	h := cpu.history
+	if h == nil {
+		return errors.New("history is not enabled")
+	}
+	if h.size == 0 {
+		return errors.New("no history to step back through")
+	}
+
+	h.head = (h.head - 1 + len(h.snapshots)) % len(h.snapshots)
+	h.size--
+	s := &h.snapshots[h.head]
+
+	// undo in reverse so a byte written twice ends up with its first value
+	for i := len(s.writes) - 1; i >= 0; i-- {
+		if cpu.disasmCache != nil {
+			cpu.invalidateDisassembly(s.writes[i].address)
+		}
+		cpu.store(s.writes[i].address, s.writes[i].value)
+	}
+
+	cpu.a = s.a
+	cpu.x = s.x
+	cpu.y = s.y
+	cpu.sp = s.sp
+	cpu.pc = s.pc
+	cpu.p = s.p
+	cpu.wait = s.wait
+	cpu.current = s.current
+	cpu.halt = s.halt
+	cpu.irq = s.irq
+	cpu.nmi = s.nmi
+	cpu.TotalCycles = s.totalCycles
+
+	return nil
+}
+
+// record the state before an instruction, reusing the oldest snapshot
+func (cpu *MOS6502) snapshot() {
+	h := cpu.history
+	s := &h.snapshots[h.head]
+
+	*s = snapshot{
+		a:           cpu.a,
+		x:           cpu.x,
+		y:           cpu.y,
+		sp:          cpu.sp,
+		pc:          cpu.pc,
+		p:           cpu.p,
+		wait:        cpu.wait,
+		current:     cpu.current,
+		halt:        cpu.halt,
+		irq:         cpu.irq,
+		nmi:         cpu.nmi,
+		totalCycles: cpu.TotalCycles,
+		writes:      s.writes[:0],
+	}
+
+	h.head = (h.head + 1) % len(h.snapshots)
+	if h.size < len(h.snapshots) {
+		h.size++
+	}
+}
+
+// remember the value about to be overwritten by the current instruction,
+// skipping anything that can not be read without a device noticing
+func (cpu *MOS6502) recordWrite(address uint16) {
+	h := cpu.history
+	if h.size == 0 {
+		return
+	}
+
+	value, ok := cpu.memory[address], true
+	if cpu.bus != nil {
+		value, ok = peekBus(cpu.bus, address)
+	}
+	if !ok {
+		return
+	}
+
+	s := &h.snapshots[(h.head-1+len(h.snapshots))%len(h.snapshots)]
+	s.writes = append(s.writes, undo{address: address, value: value})
+}
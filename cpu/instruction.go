@@ -40,6 +40,10 @@ const (
 	AM_RELATIVE
 	// operand is accumulator A
 	AM_ACCUMULATOR
+	// 65C02 only; operand is address; effective address is contents of word at address incremented by X with carry:
+	//	C.w($HHLL + X)
+	// also known as absolute indexed indirect (in JMP ($LLHH,X))
+	AM_ABSOLUTE_X_INDIRECT
 )
 
 // the instruction by name
@@ -94,6 +98,7 @@ const (
 	OPC_SED = "SED"
 	OPC_SEI = "SEI"
 	OPC_STA = "STA"
+	OPC_STP = "STP"
 	OPC_STX = "STX"
 	OPC_STY = "STY"
 	OPC_TAX = "TAX"
@@ -102,6 +107,7 @@ const (
 	OPC_TXA = "TXA"
 	OPC_TXS = "TXS"
 	OPC_TYA = "TYA"
+	OPC_WAI = "WAI"
 )
 
 // the function that will be executed for this instruction
@@ -113,6 +119,10 @@ type instruction struct {
 	size   uint8 // number of bytes to load
 	fn     executor
 	mode   AddressMode
+	// not part of the documented instruction set
+	undocumented bool
+	// read-modify-write that pays for crossing a page like a read
+	shortRMW bool
 }
 
 func NewInstruction(opc OPCode, cycles, size uint8, fn executor, mode AddressMode) *instruction {
@@ -132,6 +142,15 @@ func NewInstruction(opc OPCode, cycles, size uint8, fn executor, mode AddressMod
 	}
 }
 
+// RegisterInstruction adds or replaces an opcode in the instruction table
+// so illegal opcodes or traps can be added without editing the table.
+// fn is called with the operand resolved by the addressing mode
+func (cpu *MOS6502) RegisterInstruction(opcode uint8, opc OPCode, cycles, size uint8, fn func(*MOS6502, uint16), mode AddressMode) {
+	cpu.instructions[opcode] = NewInstruction(opc, cycles, size, func(ins *instruction, data uint16) {
+		fn(cpu, data)
+	}, mode)
+}
+
 func (i *instruction) execute(operand uint16) {
 	i.fn(i, operand)
 }
@@ -149,18 +168,18 @@ func (i *instruction) load(cpu *MOS6502) uint16 {
 
 	case AM_ABSOLUTE:
 		// full 16 bit address in LLHH format
-		lo := cpu.memory.Read(cpu.pc + 1)
-		hi := cpu.memory.Read(cpu.pc + 2)
+		lo := cpu.read(cpu.pc + 1)
+		hi := cpu.read(cpu.pc + 2)
 
-		return (uint16(hi) << 8) + uint16(lo)
+		return Word(lo, hi)
 
 	case AM_ZEROPAGE:
 		// 1 byte address in the zeropage (high byte is 0x00)
-		return uint16(cpu.memory.Read(cpu.pc + 1))
+		return uint16(cpu.read(cpu.pc + 1))
 
 	case AM_ZEROPAGE_X:
 		// first byte comes from pc
-		address := cpu.memory.Read(cpu.pc + 1)
+		address := cpu.read(cpu.pc + 1)
 		// add contents of x register
 		address += cpu.x
 		// address is 8 bits so will wrap around in the zeropage
@@ -168,7 +187,7 @@ func (i *instruction) load(cpu *MOS6502) uint16 {
 
 	case AM_ZEROPAGE_Y:
 		// first byte comes from pc
-		address := cpu.memory.Read(cpu.pc + 1)
+		address := cpu.read(cpu.pc + 1)
 		// add contents of y register
 		address += cpu.y
 		// address is 8 bits so will wrap around in the zeropage
@@ -176,60 +195,69 @@ func (i *instruction) load(cpu *MOS6502) uint16 {
 
 	case AM_ABSOLUTE_X:
 		// read 16 bit address in LLHH format
-		lo := cpu.memory.Read(cpu.pc + 1)
-		hi := cpu.memory.Read(cpu.pc + 2)
+		lo := cpu.read(cpu.pc + 1)
+		hi := cpu.read(cpu.pc + 2)
 
-		address := (uint16(hi) << 8) + uint16(lo)
+		address := Word(lo, hi)
 		offsetAddress := address + uint16(cpu.x)
 
 		// track page boundary crossing
 		if crossedPageBoundary(address, offsetAddress) {
-			cpu.additionalCycles++
+			cpu.dummyRead(address, offsetAddress)
+			if i.pageCrossPenalty() {
+				cpu.additionalCycles++
+			}
 		}
 
 		return offsetAddress
 
 	case AM_ABSOLUTE_Y:
 		// read 16 bit address in LLHH format
-		lo := cpu.memory.Read(cpu.pc + 1)
-		hi := cpu.memory.Read(cpu.pc + 2)
+		lo := cpu.read(cpu.pc + 1)
+		hi := cpu.read(cpu.pc + 2)
 
-		address := (uint16(hi) << 8) + uint16(lo)
+		address := Word(lo, hi)
 		offsetAddress := address + uint16(cpu.y)
 
 		// track page boundary crossing
 		if crossedPageBoundary(address, offsetAddress) {
-			cpu.additionalCycles++
+			cpu.dummyRead(address, offsetAddress)
+			if i.pageCrossPenalty() {
+				cpu.additionalCycles++
+			}
 		}
 
 		return offsetAddress
 
 	case AM_INDIRECT_X:
 		// first byte comes from pc
-		address := cpu.memory.Read(cpu.pc + 1)
+		address := cpu.read(cpu.pc + 1)
 
 		// add contents of x register
 		address += cpu.x
 
-		// get the lookup from this address
-		lookup := cpu.memory.ReadWord(uint16(address))
+		// get the lookup from this address, wrapping within the zeropage
+		lookup := cpu.readZeroPageWord(address)
 
 		// resolve the lookup
 		return lookup
 
 	case AM_INDIRECT_Y:
 		// first byte comes from pc
-		address := cpu.memory.Read(cpu.pc + 1)
+		address := cpu.read(cpu.pc + 1)
 
-		// get the lookup from zeropage
-		lookup := cpu.memory.ReadWord(uint16(address))
+		// get the lookup from zeropage, wrapping within the zeropage
+		lookup := cpu.readZeroPageWord(address)
 
 		// add contents of y register
 		offsetAddress := lookup + uint16(cpu.y)
 
 		// track page boundary crossing
 		if crossedPageBoundary(lookup, offsetAddress) {
-			cpu.additionalCycles++
+			cpu.dummyRead(lookup, offsetAddress)
+			if i.pageCrossPenalty() {
+				cpu.additionalCycles++
+			}
 		}
 
 		// resolve the lookup
@@ -237,16 +265,26 @@ func (i *instruction) load(cpu *MOS6502) uint16 {
 
 	case AM_INDIRECT:
 		// get the indirect address
-		lo := cpu.memory.Read(cpu.pc + 1)
-		hi := cpu.memory.Read(cpu.pc + 2)
+		lo := cpu.read(cpu.pc + 1)
+		hi := cpu.read(cpu.pc + 2)
 
-		address := (uint16(hi) << 8) + uint16(lo)
+		address := Word(lo, hi)
 
 		// read the address from the indirect address
-		return cpu.memory.ReadWord(address)
+		return Word(cpu.read(address), cpu.read(cpu.indirectHigh(address)))
+
+	case AM_ABSOLUTE_X_INDIRECT:
+		// index the table address by X
+		lo := cpu.read(cpu.pc + 1)
+		hi := cpu.read(cpu.pc + 2)
+
+		address := Word(lo, hi) + uint16(cpu.x)
+
+		// read the address from the table, the 65C02 has no page bug
+		return cpu.readWord(address)
 
 	case AM_RELATIVE:
-		address := uint16(cpu.memory.Read(cpu.pc + 1))
+		address := uint16(cpu.read(cpu.pc + 1))
 		return address
 
 	case AM_ACCUMULATOR:
@@ -257,6 +295,100 @@ func (i *instruction) load(cpu *MOS6502) uint16 {
 	}
 }
 
+// address of the high byte of a JMP indirect pointer. the NMOS 6502 does not
+// carry in to the pointer's high byte so JMP ($10FF) reads it from $1000,
+// the 65C02 fixed this at the cost of a cycle
+func (cpu *MOS6502) indirectHigh(address uint16) uint16 {
+	if cpu.cmos() {
+		return address + 1
+	}
+	return address&0xff00 | uint16(uint8(address)+1)
+}
+
+// only instructions that read their operand pay for crossing a page, stores
+// and read-modify-write instructions always take the worst case cycles apart
+// from the 65C02 shifts and rotates
+func (i *instruction) pageCrossPenalty() bool {
+	if i.shortRMW {
+		return true
+	}
+	switch i.opc {
+	case OPC_STA, OPC_STX, OPC_STY, OPC_ASL, OPC_LSR, OPC_ROL, OPC_ROR, OPC_INC, OPC_DEC:
+		return false
+	}
+	return true
+}
+
+// PeekOpcode returns the opcode at the pc and its mnemonic without executing
+// it. ok is false if the opcode is unknown
+func (cpu *MOS6502) PeekOpcode() (opcode uint8, name OPCode, ok bool) {
+	opcode = cpu.memory.Read(cpu.pc)
+	instruction := cpu.instructions[opcode]
+	if instruction == nil {
+		return opcode, "", false
+	}
+	return opcode, instruction.opc, true
+}
+
+// InstructionCycles returns the cycles the instruction at address would take
+// with the current registers, including any page cross penalty and the extra
+// cycle the 65C02 takes for decimal mode. Taken branches add 1 or 2 cycles
+// which can only be known by executing them. with SimpleTiming set only the
+// base cycles are returned. ok is false if the opcode is unknown
+func (cpu *MOS6502) InstructionCycles(address uint16) (cycles uint8, ok bool) {
+	instruction := cpu.instructions[cpu.memory.Read(address)]
+	if instruction == nil {
+		return 0, false
+	}
+
+	cycles = instruction.cycles
+	if cpu.SimpleTiming {
+		return cycles, true
+	}
+
+	decimal := instruction.opc == OPC_ADC || instruction.opc == OPC_SBC
+	if decimal && cpu.cmos() && cpu.p.isSet(P_Decimal) {
+		cycles++
+	}
+
+	if !instruction.pageCrossPenalty() {
+		return cycles, true
+	}
+
+	// work out the effective address without touching the bus
+	var base, offsetAddress uint16
+	switch instruction.mode {
+	case AM_ABSOLUTE_X:
+		base = cpu.memory.ReadWord(address + 1)
+		offsetAddress = base + uint16(cpu.x)
+	case AM_ABSOLUTE_Y:
+		base = cpu.memory.ReadWord(address + 1)
+		offsetAddress = base + uint16(cpu.y)
+	case AM_INDIRECT_Y:
+		pointer := cpu.memory.Read(address + 1)
+		base = Word(cpu.memory.Read(uint16(pointer)), cpu.memory.Read(uint16(pointer+1)))
+		offsetAddress = base + uint16(cpu.y)
+	default:
+		return cycles, true
+	}
+
+	if crossedPageBoundary(base, offsetAddress) {
+		cycles++
+	}
+
+	return cycles, true
+}
+
+// when indexing crosses a page the NMOS 6502 reads from the address before
+// its high byte is fixed up. devices on the bus can see this read, the
+// 65C02 re-reads the last operand byte instead so we skip it
+func (cpu *MOS6502) dummyRead(address, offsetAddress uint16) {
+	if cpu.cmos() {
+		return
+	}
+	cpu.read(address&0xff00 | offsetAddress&0x00ff)
+}
+
 // Helper function to check if a page boundary was crossed
 func crossedPageBoundary(oldAddress, newAddress uint16) bool {
 	return oldAddress&0xFF00 != newAddress&0xFF00
@@ -302,6 +434,9 @@ func (cpu *MOS6502) setupInstructions() {
 	// BIT
 	cpu.instructions[0x24] = NewInstruction(OPC_BIT, 3, 2, cpu.bit, AM_ZEROPAGE)
 	cpu.instructions[0x2c] = NewInstruction(OPC_BIT, 4, 3, cpu.bit, AM_ABSOLUTE)
+	if cpu.cmos() {
+		cpu.instructions[0x89] = NewInstruction(OPC_BIT, 2, 2, cpu.bit, AM_IMMEDIATE)
+	}
 
 	// BMI
 	cpu.instructions[0x30] = NewInstruction(OPC_BMI, 2, 2, cpu.bmi, AM_RELATIVE)
@@ -358,6 +493,9 @@ func (cpu *MOS6502) setupInstructions() {
 	cpu.instructions[0xd6] = NewInstruction(OPC_DEC, 6, 2, cpu.dec, AM_ZEROPAGE_X)
 	cpu.instructions[0xce] = NewInstruction(OPC_DEC, 6, 3, cpu.dec, AM_ABSOLUTE)
 	cpu.instructions[0xde] = NewInstruction(OPC_DEC, 7, 3, cpu.dec, AM_ABSOLUTE_X)
+	if cpu.cmos() {
+		cpu.instructions[0x3a] = NewInstruction(OPC_DEC, 2, 1, cpu.deca, AM_ACCUMULATOR)
+	}
 
 	// DEX
 	cpu.instructions[0xca] = NewInstruction(OPC_DEX, 2, 1, cpu.dex, AM_IMPLIED)
@@ -380,6 +518,9 @@ func (cpu *MOS6502) setupInstructions() {
 	cpu.instructions[0xf6] = NewInstruction(OPC_INC, 6, 2, cpu.inc, AM_ZEROPAGE_X)
 	cpu.instructions[0xee] = NewInstruction(OPC_INC, 6, 3, cpu.inc, AM_ABSOLUTE)
 	cpu.instructions[0xfe] = NewInstruction(OPC_INC, 7, 3, cpu.inc, AM_ABSOLUTE_X)
+	if cpu.cmos() {
+		cpu.instructions[0x1a] = NewInstruction(OPC_INC, 2, 1, cpu.inca, AM_ACCUMULATOR)
+	}
 
 	// INX
 	cpu.instructions[0xe8] = NewInstruction(OPC_INX, 2, 1, cpu.inx, AM_IMPLIED)
@@ -390,6 +531,11 @@ func (cpu *MOS6502) setupInstructions() {
 	// JMP
 	cpu.instructions[0x4c] = NewInstruction(OPC_JMP, 3, 3, cpu.jmp, AM_ABSOLUTE)
 	cpu.instructions[0x6c] = NewInstruction(OPC_JMP, 5, 3, cpu.jmp, AM_INDIRECT)
+	if cpu.cmos() {
+		// fixing the page bug cost an extra cycle
+		cpu.instructions[0x6c] = NewInstruction(OPC_JMP, 6, 3, cpu.jmp, AM_INDIRECT)
+		cpu.instructions[0x7c] = NewInstruction(OPC_JMP, 6, 3, cpu.jmp, AM_ABSOLUTE_X_INDIRECT)
+	}
 
 	// JSR
 	cpu.instructions[0x20] = NewInstruction(OPC_JSR, 6, 3, cpu.jsr, AM_ABSOLUTE)
@@ -427,6 +573,9 @@ func (cpu *MOS6502) setupInstructions() {
 
 	// NOP
 	cpu.instructions[0xea] = NewInstruction(OPC_NOP, 2, 1, cpu.nop, AM_IMPLIED)
+	if !cpu.cmos() {
+		cpu.setupUndocumentedNOPs()
+	}
 
 	// ORA
 	cpu.instructions[0x09] = NewInstruction(OPC_ORA, 2, 2, cpu.ora, AM_IMMEDIATE)
@@ -525,4 +674,38 @@ func (cpu *MOS6502) setupInstructions() {
 
 	// TYA
 	cpu.instructions[0x98] = NewInstruction(OPC_TYA, 2, 1, cpu.tya, AM_IMPLIED)
+
+	// WDC low power instructions
+	if cpu.wdc() {
+		cpu.instructions[0xcb] = NewInstruction(OPC_WAI, 3, 1, cpu.wai, AM_IMPLIED)
+		cpu.instructions[0xdb] = NewInstruction(OPC_STP, 3, 1, cpu.stp, AM_IMPLIED)
+	}
+
+	// the 65C02 shifts and rotates save a cycle on abs,X unless a page is
+	// crossed. INC and DEC abs,X still always take 7
+	if cpu.cmos() {
+		for _, opcode := range []uint8{0x1e, 0x3e, 0x5e, 0x7e} {
+			cpu.instructions[opcode].cycles = 6
+			cpu.instructions[opcode].shortRMW = true
+		}
+	}
+}
+
+// the NMOS decoder treats a number of undocumented opcodes as NOPs of various
+// sizes, the ones with a memory operand still read it and pay for page crosses
+func (cpu *MOS6502) setupUndocumentedNOPs() {
+	nop := func(cycles, size uint8, mode AddressMode, opcodes ...uint8) {
+		for _, opcode := range opcodes {
+			instruction := NewInstruction(OPC_NOP, cycles, size, cpu.nop, mode)
+			instruction.undocumented = true
+			cpu.instructions[opcode] = instruction
+		}
+	}
+
+	nop(2, 1, AM_IMPLIED, 0x1a, 0x3a, 0x5a, 0x7a, 0xda, 0xfa)
+	nop(2, 2, AM_IMMEDIATE, 0x80, 0x82, 0x89, 0xc2, 0xe2)
+	nop(3, 2, AM_ZEROPAGE, 0x04, 0x44, 0x64)
+	nop(4, 2, AM_ZEROPAGE_X, 0x14, 0x34, 0x54, 0x74, 0xd4, 0xf4)
+	nop(4, 3, AM_ABSOLUTE, 0x0c)
+	nop(4, 3, AM_ABSOLUTE_X, 0x1c, 0x3c, 0x5c, 0x7c, 0xdc, 0xfc)
 }
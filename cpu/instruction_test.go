@@ -0,0 +1,571 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// documented describes an opcode from the documented 6502 instruction set
+type documented struct {
+	opcode uint8
+	opc    OPCode
+	mode   AddressMode
+}
+
+// the 151 documented opcodes of the NMOS 6502
+var documentedInstructions = []documented{
+	// ADC
+	{0x69, OPC_ADC, AM_IMMEDIATE},
+	{0x65, OPC_ADC, AM_ZEROPAGE},
+	{0x75, OPC_ADC, AM_ZEROPAGE_X},
+	{0x6d, OPC_ADC, AM_ABSOLUTE},
+	{0x7d, OPC_ADC, AM_ABSOLUTE_X},
+	{0x79, OPC_ADC, AM_ABSOLUTE_Y},
+	{0x61, OPC_ADC, AM_INDIRECT_X},
+	{0x71, OPC_ADC, AM_INDIRECT_Y},
+	// AND
+	{0x29, OPC_AND, AM_IMMEDIATE},
+	{0x25, OPC_AND, AM_ZEROPAGE},
+	{0x35, OPC_AND, AM_ZEROPAGE_X},
+	{0x2d, OPC_AND, AM_ABSOLUTE},
+	{0x3d, OPC_AND, AM_ABSOLUTE_X},
+	{0x39, OPC_AND, AM_ABSOLUTE_Y},
+	{0x21, OPC_AND, AM_INDIRECT_X},
+	{0x31, OPC_AND, AM_INDIRECT_Y},
+	// ASL
+	{0x0a, OPC_ASL, AM_ACCUMULATOR},
+	{0x06, OPC_ASL, AM_ZEROPAGE},
+	{0x16, OPC_ASL, AM_ZEROPAGE_X},
+	{0x0e, OPC_ASL, AM_ABSOLUTE},
+	{0x1e, OPC_ASL, AM_ABSOLUTE_X},
+	// branches
+	{0x90, OPC_BCC, AM_RELATIVE},
+	{0xb0, OPC_BCS, AM_RELATIVE},
+	{0xf0, OPC_BEQ, AM_RELATIVE},
+	{0x30, OPC_BMI, AM_RELATIVE},
+	{0xd0, OPC_BNE, AM_RELATIVE},
+	{0x10, OPC_BPL, AM_RELATIVE},
+	{0x50, OPC_BVC, AM_RELATIVE},
+	{0x70, OPC_BVS, AM_RELATIVE},
+	// BIT
+	{0x24, OPC_BIT, AM_ZEROPAGE},
+	{0x2c, OPC_BIT, AM_ABSOLUTE},
+	// BRK
+	{0x00, OPC_BRK, AM_IMPLIED},
+	// flags
+	{0x18, OPC_CLC, AM_IMPLIED},
+	{0xd8, OPC_CLD, AM_IMPLIED},
+	{0x58, OPC_CLI, AM_IMPLIED},
+	{0xb8, OPC_CLV, AM_IMPLIED},
+	{0x38, OPC_SEC, AM_IMPLIED},
+	{0xf8, OPC_SED, AM_IMPLIED},
+	{0x78, OPC_SEI, AM_IMPLIED},
+	// CMP
+	{0xc9, OPC_CMP, AM_IMMEDIATE},
+	{0xc5, OPC_CMP, AM_ZEROPAGE},
+	{0xd5, OPC_CMP, AM_ZEROPAGE_X},
+	{0xcd, OPC_CMP, AM_ABSOLUTE},
+	{0xdd, OPC_CMP, AM_ABSOLUTE_X},
+	{0xd9, OPC_CMP, AM_ABSOLUTE_Y},
+	{0xc1, OPC_CMP, AM_INDIRECT_X},
+	{0xd1, OPC_CMP, AM_INDIRECT_Y},
+	// CPX
+	{0xe0, OPC_CPX, AM_IMMEDIATE},
+	{0xe4, OPC_CPX, AM_ZEROPAGE},
+	{0xec, OPC_CPX, AM_ABSOLUTE},
+	// CPY
+	{0xc0, OPC_CPY, AM_IMMEDIATE},
+	{0xc4, OPC_CPY, AM_ZEROPAGE},
+	{0xcc, OPC_CPY, AM_ABSOLUTE},
+	// DEC
+	{0xc6, OPC_DEC, AM_ZEROPAGE},
+	{0xd6, OPC_DEC, AM_ZEROPAGE_X},
+	{0xce, OPC_DEC, AM_ABSOLUTE},
+	{0xde, OPC_DEC, AM_ABSOLUTE_X},
+	{0xca, OPC_DEX, AM_IMPLIED},
+	{0x88, OPC_DEY, AM_IMPLIED},
+	// EOR
+	{0x49, OPC_EOR, AM_IMMEDIATE},
+	{0x45, OPC_EOR, AM_ZEROPAGE},
+	{0x55, OPC_EOR, AM_ZEROPAGE_X},
+	{0x4d, OPC_EOR, AM_ABSOLUTE},
+	{0x5d, OPC_EOR, AM_ABSOLUTE_X},
+	{0x59, OPC_EOR, AM_ABSOLUTE_Y},
+	{0x41, OPC_EOR, AM_INDIRECT_X},
+	{0x51, OPC_EOR, AM_INDIRECT_Y},
+	// INC
+	{0xe6, OPC_INC, AM_ZEROPAGE},
+	{0xf6, OPC_INC, AM_ZEROPAGE_X},
+	{0xee, OPC_INC, AM_ABSOLUTE},
+	{0xfe, OPC_INC, AM_ABSOLUTE_X},
+	{0xe8, OPC_INX, AM_IMPLIED},
+	{0xc8, OPC_INY, AM_IMPLIED},
+	// JMP
+	{0x4c, OPC_JMP, AM_ABSOLUTE},
+	{0x6c, OPC_JMP, AM_INDIRECT},
+	// JSR
+	{0x20, OPC_JSR, AM_ABSOLUTE},
+	// LDA
+	{0xa9, OPC_LDA, AM_IMMEDIATE},
+	{0xa5, OPC_LDA, AM_ZEROPAGE},
+	{0xb5, OPC_LDA, AM_ZEROPAGE_X},
+	{0xad, OPC_LDA, AM_ABSOLUTE},
+	{0xbd, OPC_LDA, AM_ABSOLUTE_X},
+	{0xb9, OPC_LDA, AM_ABSOLUTE_Y},
+	{0xa1, OPC_LDA, AM_INDIRECT_X},
+	{0xb1, OPC_LDA, AM_INDIRECT_Y},
+	// LDX
+	{0xa2, OPC_LDX, AM_IMMEDIATE},
+	{0xa6, OPC_LDX, AM_ZEROPAGE},
+	{0xb6, OPC_LDX, AM_ZEROPAGE_Y},
+	{0xae, OPC_LDX, AM_ABSOLUTE},
+	{0xbe, OPC_LDX, AM_ABSOLUTE_Y},
+	// LDY
+	{0xa0, OPC_LDY, AM_IMMEDIATE},
+	{0xa4, OPC_LDY, AM_ZEROPAGE},
+	{0xb4, OPC_LDY, AM_ZEROPAGE_X},
+	{0xac, OPC_LDY, AM_ABSOLUTE},
+	{0xbc, OPC_LDY, AM_ABSOLUTE_X},
+	// LSR
+	{0x4a, OPC_LSR, AM_ACCUMULATOR},
+	{0x46, OPC_LSR, AM_ZEROPAGE},
+	{0x56, OPC_LSR, AM_ZEROPAGE_X},
+	{0x4e, OPC_LSR, AM_ABSOLUTE},
+	{0x5e, OPC_LSR, AM_ABSOLUTE_X},
+	// NOP
+	{0xea, OPC_NOP, AM_IMPLIED},
+	// ORA
+	{0x09, OPC_ORA, AM_IMMEDIATE},
+	{0x05, OPC_ORA, AM_ZEROPAGE},
+	{0x15, OPC_ORA, AM_ZEROPAGE_X},
+	{0x0d, OPC_ORA, AM_ABSOLUTE},
+	{0x1d, OPC_ORA, AM_ABSOLUTE_X},
+	{0x19, OPC_ORA, AM_ABSOLUTE_Y},
+	{0x01, OPC_ORA, AM_INDIRECT_X},
+	{0x11, OPC_ORA, AM_INDIRECT_Y},
+	// stack
+	{0x48, OPC_PHA, AM_IMPLIED},
+	{0x08, OPC_PHP, AM_IMPLIED},
+	{0x68, OPC_PLA, AM_IMPLIED},
+	{0x28, OPC_PLP, AM_IMPLIED},
+	// ROL
+	{0x2a, OPC_ROL, AM_ACCUMULATOR},
+	{0x26, OPC_ROL, AM_ZEROPAGE},
+	{0x36, OPC_ROL, AM_ZEROPAGE_X},
+	{0x2e, OPC_ROL, AM_ABSOLUTE},
+	{0x3e, OPC_ROL, AM_ABSOLUTE_X},
+	// ROR
+	{0x6a, OPC_ROR, AM_ACCUMULATOR},
+	{0x66, OPC_ROR, AM_ZEROPAGE},
+	{0x76, OPC_ROR, AM_ZEROPAGE_X},
+	{0x6e, OPC_ROR, AM_ABSOLUTE},
+	{0x7e, OPC_ROR, AM_ABSOLUTE_X},
+	// returns
+	{0x40, OPC_RTI, AM_IMPLIED},
+	{0x60, OPC_RTS, AM_IMPLIED},
+	// SBC
+	{0xe9, OPC_SBC, AM_IMMEDIATE},
+	{0xe5, OPC_SBC, AM_ZEROPAGE},
+	{0xf5, OPC_SBC, AM_ZEROPAGE_X},
+	{0xed, OPC_SBC, AM_ABSOLUTE},
+	{0xfd, OPC_SBC, AM_ABSOLUTE_X},
+	{0xf9, OPC_SBC, AM_ABSOLUTE_Y},
+	{0xe1, OPC_SBC, AM_INDIRECT_X},
+	{0xf1, OPC_SBC, AM_INDIRECT_Y},
+	// STA
+	{0x85, OPC_STA, AM_ZEROPAGE},
+	{0x95, OPC_STA, AM_ZEROPAGE_X},
+	{0x8d, OPC_STA, AM_ABSOLUTE},
+	{0x9d, OPC_STA, AM_ABSOLUTE_X},
+	{0x99, OPC_STA, AM_ABSOLUTE_Y},
+	{0x81, OPC_STA, AM_INDIRECT_X},
+	{0x91, OPC_STA, AM_INDIRECT_Y},
+	// STX
+	{0x86, OPC_STX, AM_ZEROPAGE},
+	{0x96, OPC_STX, AM_ZEROPAGE_Y},
+	{0x8e, OPC_STX, AM_ABSOLUTE},
+	// STY
+	{0x84, OPC_STY, AM_ZEROPAGE},
+	{0x94, OPC_STY, AM_ZEROPAGE_X},
+	{0x8c, OPC_STY, AM_ABSOLUTE},
+	// transfers
+	{0xaa, OPC_TAX, AM_IMPLIED},
+	{0xa8, OPC_TAY, AM_IMPLIED},
+	{0xba, OPC_TSX, AM_IMPLIED},
+	{0x8a, OPC_TXA, AM_IMPLIED},
+	{0x9a, OPC_TXS, AM_IMPLIED},
+	{0x98, OPC_TYA, AM_IMPLIED},
+}
+
+func TestDocumentedInstructions(t *testing.T) {
+	if len(documentedInstructions) != 151 {
+		t.Fatalf("expected 151 documented instructions got %d", len(documentedInstructions))
+	}
+
+	cpu := NewMOS6502()
+
+	var gaps []string
+	for _, d := range documentedInstructions {
+		instruction := cpu.instructions[d.opcode]
+		switch {
+		case instruction == nil:
+			gaps = append(gaps, fmt.Sprintf("%02x: missing %s", d.opcode, d.opc))
+		case instruction.opc != d.opc || instruction.mode != d.mode:
+			gaps = append(gaps, fmt.Sprintf(
+				"%02x: expected %s mode %d got %s mode %d",
+				d.opcode, d.opc, d.mode, instruction.opc, instruction.mode,
+			))
+		}
+	}
+
+	t.Logf("%d/%d documented instructions implemented", len(documentedInstructions)-len(gaps), len(documentedInstructions))
+
+	if len(gaps) > 0 {
+		t.Errorf("gaps in the instruction table:\n%s", strings.Join(gaps, "\n"))
+	}
+}
+
+func TestInstructionTableConsistency(t *testing.T) {
+	// bytes and the range of base cycles each addressing mode can take
+	modes := map[AddressMode]struct {
+		size     uint8
+		min, max uint8
+	}{
+		AM_IMPLIED:             {1, 2, 7},
+		AM_ACCUMULATOR:         {1, 2, 2},
+		AM_IMMEDIATE:           {2, 2, 2},
+		AM_ZEROPAGE:            {2, 3, 5},
+		AM_ZEROPAGE_X:          {2, 4, 6},
+		AM_ZEROPAGE_Y:          {2, 4, 4},
+		AM_ABSOLUTE:            {3, 3, 6},
+		AM_ABSOLUTE_X:          {3, 4, 7},
+		AM_ABSOLUTE_Y:          {3, 4, 5},
+		AM_INDIRECT:            {3, 5, 6},
+		AM_INDIRECT_X:          {2, 6, 6},
+		AM_INDIRECT_Y:          {2, 5, 6},
+		AM_RELATIVE:            {2, 2, 2},
+		AM_ABSOLUTE_X_INDIRECT: {3, 6, 6},
+	}
+
+	for _, variant := range []Variant{NMOS6502, CMOS65C02, WDC65C02} {
+		cpu := NewMOS6502()
+		cpu.SetVariant(variant)
+
+		for opcode, instruction := range cpu.instructions {
+			if instruction == nil {
+				continue
+			}
+
+			expected, ok := modes[instruction.mode]
+			if !ok {
+				t.Errorf("variant %d %02x %s: unknown mode %d", variant, opcode, instruction.opc, instruction.mode)
+				continue
+			}
+			if instruction.size != expected.size {
+				t.Errorf("variant %d %02x %s: expected %d bytes got %d", variant, opcode, instruction.opc, expected.size, instruction.size)
+			}
+			if instruction.cycles < expected.min || instruction.cycles > expected.max {
+				t.Errorf("variant %d %02x %s: expected %d-%d cycles got %d", variant, opcode, instruction.opc, expected.min, expected.max, instruction.cycles)
+			}
+
+			// only reads from memory take a page cross penalty
+			if instruction.mode == AM_ABSOLUTE_Y && instruction.cycles == 5 && instruction.opc != OPC_STA {
+				t.Errorf("variant %d %02x %s: only STA abs,Y takes 5 cycles", variant, opcode, instruction.opc)
+			}
+		}
+	}
+}
+
+func TestRegisterInstruction(t *testing.T) {
+	// swap A and X
+	swap := func(cpu *MOS6502, data uint16) {
+		cpu.a, cpu.x = cpu.x, cpu.a
+		cpu.SetNZ(cpu.a)
+	}
+
+	cpu := setup([]uint8{0x02, 0xea}, nil)
+	cpu.RegisterInstruction(0x02, OPCode("SWP"), 2, 1, swap, AM_IMPLIED)
+
+	cpu.a = 0x01
+	cpu.x = 0x80
+
+	cpu.Step()
+
+	expect8(t, cpu.a, newUint8(0x80))
+	expect8(t, cpu.x, newUint8(0x01))
+	expect16(t, cpu.pc, newUint16(ProgramStart+1))
+	expectFlag(t, cpu, P_Negative, true)
+
+	if cpu.Halt() != Continue {
+		t.Errorf("expected cpu to continue got %d", cpu.Halt())
+	}
+	if cpu.TotalCycles != 2 {
+		t.Errorf("expected 2 total cycles got %d", cpu.TotalCycles)
+	}
+}
+
+func TestRegisterInstructionOperand(t *testing.T) {
+	// store X + 1 at the operand address
+	stxi := func(cpu *MOS6502, data uint16) {
+		cpu.write(data, cpu.x+1)
+	}
+
+	cpu := setup([]uint8{0x02, 0x42}, nil)
+	cpu.RegisterInstruction(0x02, OPCode("STXI"), 3, 2, stxi, AM_ZEROPAGE)
+
+	cpu.x = 0x41
+	cpu.Step()
+
+	expect8(t, cpu.memory[0x42], newUint8(0x42))
+	expect16(t, cpu.pc, newUint16(ProgramStart+2))
+}
+
+// bus that records the address of every read
+type readLog struct {
+	*Memory
+	reads []uint16
+}
+
+func (r *readLog) Read(address uint16) uint8 {
+	r.reads = append(r.reads, address)
+	return r.Memory.Read(address)
+}
+
+func TestDummyRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant Variant
+		program []uint8
+		x       uint8
+		expect  []uint16
+	}{
+		{
+			name:    "lda abs,x no page cross",
+			program: []uint8{0xbd, 0x10, 0x12}, // LDA $1210,X
+			x:       0x01,
+			expect:  []uint16{0xdd00, 0xdd01, 0xdd02, 0x1211},
+		},
+		{
+			name:    "lda abs,x page cross",
+			program: []uint8{0xbd, 0xff, 0x12}, // LDA $12FF,X
+			x:       0x02,
+			// reads $1201 before fixing up the high byte
+			expect: []uint16{0xdd00, 0xdd01, 0xdd02, 0x1201, 0x1301},
+		},
+		{
+			name:    "lda abs,x page cross cmos",
+			variant: CMOS65C02,
+			program: []uint8{0xbd, 0xff, 0x12}, // LDA $12FF,X
+			x:       0x02,
+			expect:  []uint16{0xdd00, 0xdd01, 0xdd02, 0x1301},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := setup(tc.program, nil)
+			cpu.SetVariant(tc.variant)
+			cpu.x = tc.x
+
+			bus := &readLog{Memory: cpu.memory}
+			cpu.SetBus(bus)
+
+			cpu.Step()
+
+			if fmt.Sprint(bus.reads) != fmt.Sprint(tc.expect) {
+				t.Errorf("expected reads %04x got %04x", tc.expect, bus.reads)
+			}
+		})
+	}
+}
+
+func TestInstructionCycles(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint8
+		x, y    uint8
+		memory  map[uint16]uint8
+		cycles  uint8
+	}{
+		{"lda immediate", []uint8{0xa9, 0x01}, 0, 0, nil, 2},
+		{"lda zeropage", []uint8{0xa5, 0x10}, 0, 0, nil, 3},
+		{"lda absolute", []uint8{0xad, 0x00, 0x12}, 0, 0, nil, 4},
+		{"lda absolute,x", []uint8{0xbd, 0x00, 0x12}, 0x01, 0, nil, 4},
+		{"lda absolute,x page cross", []uint8{0xbd, 0xff, 0x12}, 0x01, 0, nil, 5},
+		{"lda absolute,y page cross", []uint8{0xb9, 0xff, 0x12}, 0, 0x01, nil, 5},
+		{"lda (indirect),y", []uint8{0xb1, 0x10}, 0, 0x01, map[uint16]uint8{0x10: 0x00, 0x11: 0x12}, 5},
+		{"lda (indirect),y page cross", []uint8{0xb1, 0x10}, 0, 0x01, map[uint16]uint8{0x10: 0xff, 0x11: 0x12}, 6},
+		{"sta absolute,x page cross", []uint8{0x9d, 0xff, 0x12}, 0x01, 0, nil, 5},
+		// (indirect,X) is always 6, the pointer wraps in the zeropage and
+		// the address it points at is never indexed
+		{"lda (indirect,x)", []uint8{0xa1, 0x10}, 0x01, 0, map[uint16]uint8{0x11: 0x00, 0x12: 0x12}, 6},
+		{"lda (indirect,x) pointing at a page end", []uint8{0xa1, 0x10}, 0x01, 0, map[uint16]uint8{0x11: 0xff, 0x12: 0x12}, 6},
+		{"lda (indirect,x) zeropage wrap", []uint8{0xa1, 0xff}, 0x01, 0, map[uint16]uint8{0x00: 0xff, 0x01: 0x12}, 6},
+		{"sta (indirect,x)", []uint8{0x81, 0x10}, 0x01, 0, map[uint16]uint8{0x11: 0xff, 0x12: 0x12}, 6},
+		// where stores through (indirect),Y always take the penalty
+		{"sta (indirect),y", []uint8{0x91, 0x10}, 0, 0x01, map[uint16]uint8{0x10: 0x00, 0x11: 0x12}, 6},
+		{"inc absolute,x", []uint8{0xfe, 0x00, 0x12}, 0, 0, nil, 7},
+		{"jsr", []uint8{0x20, 0x00, 0x12}, 0, 0, nil, 6},
+		{"brk", []uint8{0x00}, 0, 0, nil, 7},
+		{"beq not taken", []uint8{0xf0, 0x10}, 0, 0, nil, 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := setup(tc.program, tc.memory)
+			cpu.x = tc.x
+			cpu.y = tc.y
+
+			cycles, ok := cpu.InstructionCycles(ProgramStart)
+			if !ok {
+				t.Fatal("expected a known instruction")
+			}
+			if cycles != tc.cycles {
+				t.Errorf("expected %d cycles got %d", tc.cycles, cycles)
+			}
+
+			// matches what executing it costs
+			cpu.Step()
+			if cpu.TotalCycles != uint64(tc.cycles) {
+				t.Errorf("expected execution to take %d cycles got %d", tc.cycles, cpu.TotalCycles)
+			}
+		})
+	}
+
+	// settings that change the cycles taken
+	for _, tc := range []struct {
+		name    string
+		program []uint8
+		variant Variant
+		decimal bool
+		simple  bool
+		cycles  uint8
+	}{
+		{"simple timing ignores a page cross", []uint8{0xbd, 0xff, 0x12}, NMOS6502, false, true, 4},
+		{"simple timing ignores decimal", []uint8{0x7d, 0xff, 0x12}, CMOS65C02, true, true, 4},
+		{"decimal adc on nmos", []uint8{0x69, 0x01}, NMOS6502, true, false, 2},
+		{"decimal adc on cmos", []uint8{0x69, 0x01}, CMOS65C02, true, false, 3},
+		{"decimal sbc on cmos with a page cross", []uint8{0xfd, 0xff, 0x12}, CMOS65C02, true, false, 6},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := setup(tc.program, nil)
+			cpu.SetVariant(tc.variant)
+			cpu.p.set(P_Decimal, tc.decimal)
+			cpu.SimpleTiming = tc.simple
+			cpu.x = 0x01
+
+			if cycles, _ := cpu.InstructionCycles(ProgramStart); cycles != tc.cycles {
+				t.Errorf("expected %d cycles got %d", tc.cycles, cycles)
+			}
+
+			cpu.Step()
+			if cpu.TotalCycles != uint64(tc.cycles) {
+				t.Errorf("expected execution to take %d cycles got %d", tc.cycles, cpu.TotalCycles)
+			}
+		})
+	}
+
+	cpu := setup([]uint8{0x02}, nil)
+	if _, ok := cpu.InstructionCycles(ProgramStart); ok {
+		t.Error("expected unknown opcode not to be ok")
+	}
+}
+
+func TestRMWAbsoluteXCycles(t *testing.T) {
+	tests := []struct {
+		name     string
+		opcode   uint8
+		variant  Variant
+		cycles   uint8
+		crossing uint8
+	}{
+		{"INC", 0xfe, NMOS6502, 7, 7},
+		{"DEC", 0xde, NMOS6502, 7, 7},
+		{"ASL", 0x1e, NMOS6502, 7, 7},
+		{"ROR", 0x7e, NMOS6502, 7, 7},
+		{"INC", 0xfe, CMOS65C02, 7, 7},
+		{"DEC", 0xde, CMOS65C02, 7, 7},
+		{"ASL", 0x1e, CMOS65C02, 6, 7},
+		{"LSR", 0x5e, CMOS65C02, 6, 7},
+		{"ROL", 0x3e, CMOS65C02, 6, 7},
+		{"ROR", 0x7e, CMOS65C02, 6, 7},
+	}
+
+	for _, tc := range tests {
+		for _, cross := range []bool{false, true} {
+			lo := uint8(0x00)
+			expected := tc.cycles
+			if cross {
+				lo = 0xff
+				expected = tc.crossing
+			}
+
+			cpu := setup([]uint8{tc.opcode, lo, 0x12}, nil)
+			cpu.SetVariant(tc.variant)
+			cpu.x = 0x01
+
+			cycles, _ := cpu.InstructionCycles(ProgramStart)
+			cpu.Step()
+
+			if cpu.TotalCycles != uint64(expected) || cycles != expected {
+				t.Errorf("%s abs,X variant %d crossing %t: expected %d cycles got %d (predicted %d)", tc.name, tc.variant, cross, expected, cpu.TotalCycles, cycles)
+			}
+		}
+	}
+}
+
+func TestPeekOpcode(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x01, // LDA #$01
+		0xe8, // INX
+		0x02, // unknown
+	}, nil)
+	cpu.Quiet = true
+
+	for _, expected := range []struct {
+		opcode uint8
+		name   OPCode
+		ok     bool
+	}{
+		{0xa9, OPC_LDA, true},
+		{0xe8, OPC_INX, true},
+		{0x02, "", false},
+	} {
+		opcode, name, ok := cpu.PeekOpcode()
+		if opcode != expected.opcode || name != expected.name || ok != expected.ok {
+			t.Errorf("expected %02x %q %t got %02x %q %t", expected.opcode, expected.name, expected.ok, opcode, name, ok)
+		}
+
+		// peeking does not move the pc
+		pc := cpu.pc
+		cpu.PeekOpcode()
+		expect16(t, cpu.pc, &pc)
+
+		cpu.Step()
+	}
+}
+
+func TestSimpleTiming(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		program []uint8
+		cycles  uint64
+	}{
+		{"lda absolute,x page cross", []uint8{0xbd, 0xff, 0x12}, 4},
+		{"bne taken across a page", []uint8{0xd0, 0x7f}, 2},
+	} {
+		cpu := setup(tc.program, nil)
+		cpu.x = 0x01
+		cpu.Step()
+		if cpu.TotalCycles <= tc.cycles {
+			t.Fatalf("%s: expected extra cycles without simple timing got %d", tc.name, cpu.TotalCycles)
+		}
+
+		cpu = setup(tc.program, nil)
+		cpu.x = 0x01
+		cpu.SimpleTiming = true
+		cpu.Step()
+		expect64(t, cpu.TotalCycles, &tc.cycles)
+	}
+}
@@ -0,0 +1,107 @@
+package cpu
+
+import (
+	"log"
+)
+
+// cycles taken to enter an interrupt handler
+const interruptCycles = 7
+
+// IRQ raises an interrupt request. It is taken at the next instruction
+// boundary and stays pending while interrupts are disabled
+func (cpu *MOS6502) IRQ() {
+	cpu.irq = true
+	cpu.wake()
+}
+
+// NMI raises a non-maskable interrupt which is taken at the next
+// instruction boundary regardless of the interrupt disable flag
+func (cpu *MOS6502) NMI() {
+	cpu.wake()
+	if cpu.brkHijacked() {
+		cpu.pc = cpu.readWord(NMIVectorLow)
+		return
+	}
+	cpu.nmi = true
+}
+
+// an interrupt wakes a cpu waiting in WAI, a stopped cpu needs a reset
+func (cpu *MOS6502) wake() {
+	if cpu.halt == HaltWaitingForInterrupt {
+		cpu.halt = Continue
+	}
+}
+
+// on the NMOS 6502 BRK shares its entry sequence with the interrupts. an
+// NMI arriving before BRK fetches its vector (cycles 6 and 7) hijacks it,
+// the status BRK pushed keeps B set but the NMI vector is used and the NMI
+// is not taken again. an IRQ arriving during BRK would use the same vector
+// so nothing changes, it stays pending and is taken once interrupts are
+// enabled again. the 65C02 always finishes BRK before taking the NMI
+const brkHijackWait = 3
+
+// true if an interrupt raised now would hijack a BRK in progress
+func (cpu *MOS6502) brkHijacked() bool {
+	if cpu.cmos() || cpu.current == nil || cpu.current.opc != OPC_BRK {
+		return false
+	}
+	return cpu.wait >= brkHijackWait
+}
+
+// enter the handler of any pending interrupt, NMI takes priority over IRQ
+func (cpu *MOS6502) serviceInterrupt() bool {
+	switch {
+	case cpu.nmi:
+		cpu.nmi = false
+		cpu.interrupt(NMIVectorLow, false)
+
+	case cpu.irq && !cpu.p.isSet(P_InterruptDisable):
+		cpu.irq = false
+		cpu.interrupt(IRQVectorLow, false)
+
+	default:
+		return false
+	}
+
+	// entering the handler takes as long as an instruction
+	cpu.current = nil
+	cpu.TotalCycles += interruptCycles
+	cpu.wait = interruptCycles - 1
+
+	return true
+}
+
+// enter an interrupt handler, pushing the pc and status before jumping
+// through the vector. the break flag is only set in the pushed status
+// when entered from BRK
+func (cpu *MOS6502) interrupt(vector uint16, brk bool) {
+	// entry still happens so the stack can be inspected after the halt
+	if cpu.StackGuard != 0 && int(cpu.sp)-3 < int(cpu.StackGuard) {
+		cpu.halt = HaltStackGuard
+		if !cpu.Quiet {
+			log.Printf("stack guard %02x crossed entering interrupt with sp %02x at %04x", cpu.StackGuard, cpu.sp, cpu.pc)
+		}
+	}
+
+	// push return data to stack
+	lo, hi := Bytes(cpu.pc)
+	cpu.push(hi)
+	cpu.push(lo)
+
+	// push status register to stack with bit 5 set
+	p := cpu.p
+	p.set(P_Break, brk)
+	p.set(P_Reserved, true)
+	cpu.push(uint8(p))
+
+	// set intterupt disable
+	cpu.p.set(P_InterruptDisable, true)
+
+	// the 65C02 clears decimal mode on entry, the NMOS 6502 leaves it as is
+	if cpu.cmos() {
+		cpu.p.set(P_Decimal, false)
+	}
+
+	// push interrupt vector to pc
+	cpu.pc = cpu.readWord(vector)
+}
@@ -0,0 +1,351 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// setup a cpu with handlers for IRQ at $3000 and NMI at $4000
+func setupInterrupts(program []uint8) *MOS6502 {
+	return setup(program, map[uint16]uint8{
+		IRQVectorLow:  0x00,
+		IRQVectorHigh: 0x30,
+		NMIVectorLow:  0x00,
+		NMIVectorHigh: 0x40,
+		// INY, RTI
+		0x3000: 0xc8,
+		0x3001: 0x40,
+		// INX, RTI
+		0x4000: 0xe8,
+		0x4001: 0x40,
+	})
+}
+
+func TestIRQ(t *testing.T) {
+	// CLI, NOP
+	cpu := setupInterrupts([]uint8{0x58, 0xea})
+
+	cpu.Step()
+	cpu.IRQ()
+
+	// enter the handler
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(0x3000))
+	expect8(t, cpu.sp, newUint8(StackReset-0x03))
+	expectFlag(t, cpu, P_InterruptDisable, true)
+
+	// pushed status has the break flag clear
+	expect8(t, cpu.memory[stackAddress(StackReset)], newUint8(0xdd))
+	expect8(t, cpu.memory[stackAddress(StackReset-0x1)], newUint8(0x01))
+	expect8(t, cpu.memory[stackAddress(StackReset-0x2)], newUint8(0x20))
+
+	// INY, RTI
+	cpu.Step()
+	cpu.Step()
+	expect8(t, cpu.y, newUint8(0x01))
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x01))
+	expectFlag(t, cpu, P_InterruptDisable, false)
+
+	// NOP without taking the interrupt again
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x02))
+	expect8(t, cpu.y, newUint8(0x01))
+}
+
+func TestIRQMasked(t *testing.T) {
+	// SEI, NOP, CLI, NOP
+	cpu := setupInterrupts([]uint8{0x78, 0xea, 0x58, 0xea})
+
+	cpu.Step()
+	cpu.IRQ()
+
+	// masked so stays pending
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x02))
+
+	// taken once interrupts are enabled
+	cpu.Step()
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(0x3000))
+}
+
+func TestNMI(t *testing.T) {
+	// SEI, NOP
+	cpu := setupInterrupts([]uint8{0x78, 0xea})
+
+	cpu.Step()
+	cpu.NMI()
+
+	// taken even though interrupts are disabled
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(0x4000))
+
+	// INX, RTI
+	cpu.Step()
+	cpu.Step()
+	expect8(t, cpu.x, newUint8(0x01))
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x01))
+}
+
+func TestInterruptCycles(t *testing.T) {
+	// expect the step to take cycles counted in TotalCycles
+	step := func(cpu *MOS6502, cycles uint64) {
+		t.Helper()
+		before := cpu.TotalCycles
+		cpu.Step()
+		if taken := cpu.TotalCycles - before; taken != cycles {
+			t.Errorf("expected %d cycles got %d", cycles, taken)
+		}
+	}
+
+	// CLI, NOP
+	cpu := setupInterrupts([]uint8{0x58, 0xea})
+	cpu.Step()
+	cpu.IRQ()
+	step(cpu, 7)
+	expect16(t, cpu.pc, newUint16(0x3000))
+	cpu.Step()
+	// RTI
+	step(cpu, 6)
+
+	cpu = setupInterrupts([]uint8{0xea})
+	cpu.NMI()
+	step(cpu, 7)
+	expect16(t, cpu.pc, newUint16(0x4000))
+	cpu.Step()
+	step(cpu, 6)
+
+	// BRK
+	cpu = setupInterrupts([]uint8{0x00})
+	step(cpu, 7)
+	expect16(t, cpu.pc, newUint16(0x3000))
+	cpu.Step()
+	step(cpu, 6)
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x02))
+}
+
+func TestNMIPriority(t *testing.T) {
+	// CLI, NOP
+	cpu := setupInterrupts([]uint8{0x58, 0xea})
+
+	cpu.Step()
+	cpu.IRQ()
+	cpu.NMI()
+
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(0x4000))
+}
+
+// run BRK for n cycles and then raise an interrupt
+func brkThen(t *testing.T, variant Variant, n int, raise func(cpu *MOS6502)) *MOS6502 {
+	t.Helper()
+
+	// CLI, BRK, padding, NOP
+	cpu := setupInterrupts([]uint8{0x58, 0x00, 0xff, 0xea})
+	cpu.SetVariant(variant)
+	cpu.Step()
+
+	for i := 0; i < n; i++ {
+		cpu.Cycle()
+	}
+	raise(cpu)
+
+	// finish the BRK
+	for cpu.Busy() {
+		cpu.Cycle()
+	}
+
+	return cpu
+}
+
+func TestBRKHijackNMI(t *testing.T) {
+	cpu := brkThen(t, NMOS6502, 2, (*MOS6502).NMI)
+
+	// BRK went through the NMI vector with B set in the pushed status
+	expect16(t, cpu.pc, newUint16(0x4000))
+	expect8(t, cpu.memory[stackAddress(StackReset-0x2)], newUint8(0x30))
+	expect64(t, cpu.TotalCycles, newUint64(2+7))
+
+	// INX, RTI then the NOP without taking the NMI again
+	cpu.Step()
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x03))
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x04))
+	expect8(t, cpu.x, newUint8(0x01))
+}
+
+func TestBRKNMIAfterVectorFetch(t *testing.T) {
+	cpu := brkThen(t, NMOS6502, 5, (*MOS6502).NMI)
+
+	// too late to hijack, BRK uses the IRQ vector and the NMI follows
+	expect16(t, cpu.pc, newUint16(0x3000))
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(0x4000))
+}
+
+func TestBRKNMICMOS(t *testing.T) {
+	cpu := brkThen(t, CMOS65C02, 2, (*MOS6502).NMI)
+
+	// the 65C02 finishes the BRK before taking the NMI
+	expect16(t, cpu.pc, newUint16(0x3000))
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(0x4000))
+}
+
+func TestBRKIRQ(t *testing.T) {
+	cpu := brkThen(t, NMOS6502, 2, (*MOS6502).IRQ)
+
+	// shares the vector so the handler sees B set
+	expect16(t, cpu.pc, newUint16(0x3000))
+	expect8(t, cpu.memory[stackAddress(StackReset-0x2)], newUint8(0x30))
+
+	// INY, RTI restores I clear and the IRQ is taken
+	cpu.Step()
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x03))
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(0x3000))
+
+	// this time the pushed status has B clear
+	expect8(t, cpu.memory[stackAddress(StackReset-0x2)], newUint8(0x20))
+}
+
+func TestStackGuard(t *testing.T) {
+	// CLI, JMP * with a handler that re-enables interrupts and spins so
+	// every IRQ nests inside the last
+	cpu := setup([]uint8{0x58, 0x4c, 0x01, 0xdd}, map[uint16]uint8{
+		IRQVectorLow:  0x00,
+		IRQVectorHigh: 0x30,
+		// CLI, JMP *
+		0x3000: 0x58,
+		0x3001: 0x4c,
+		0x3002: 0x01,
+		0x3003: 0x30,
+	})
+	cpu.StackGuard = 0xf0
+	cpu.Quiet = true
+
+	interrupts := 0
+	for i := 0; i < 100 && cpu.Halt() == Continue; i++ {
+		cpu.IRQ()
+		cpu.Step()
+		if cpu.pc == 0x3000 {
+			interrupts++
+		}
+	}
+
+	if cpu.Halt() != HaltStackGuard {
+		t.Fatalf("expected %s got %s", HaltStackGuard, cpu.Halt())
+	}
+	// 4 fit above the guard, the 5th would cross it
+	if interrupts != 5 {
+		t.Errorf("expected the guard on the 5th interrupt got %d", interrupts)
+	}
+	if cpu.sp >= cpu.StackGuard {
+		t.Errorf("expected sp below %02x got %02x", cpu.StackGuard, cpu.sp)
+	}
+}
+
+func TestStackGuardDisabled(t *testing.T) {
+	// CLI, NOP with the stack about to wrap
+	cpu := setup([]uint8{0x58, 0xea}, map[uint16]uint8{
+		IRQVectorLow:  0x00,
+		IRQVectorHigh: 0x30,
+	})
+	cpu.sp = 0x01
+
+	cpu.Step()
+	cpu.IRQ()
+	cpu.Step()
+
+	if cpu.Halt() != Continue {
+		t.Fatalf("expected a zero guard to be disabled got %s", cpu.Halt())
+	}
+	expect16(t, cpu.pc, newUint16(0x3000))
+	expect8(t, cpu.sp, newUint8(0xfe))
+}
+
+func TestWAI(t *testing.T) {
+	// CLI, WAI, INX
+	cpu := setupInterrupts([]uint8{0x58, 0xcb, 0xe8})
+	cpu.SetVariant(WDC65C02)
+
+	cpu.Step()
+	cpu.Step()
+	if cpu.Halt() != HaltWaitingForInterrupt {
+		t.Fatalf("expected %s got %s", HaltWaitingForInterrupt, cpu.Halt())
+	}
+
+	// nothing runs while waiting
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x02))
+
+	// the IRQ wakes it and is taken
+	cpu.IRQ()
+	expect8(t, uint8(cpu.Halt()), newUint8(uint8(Continue)))
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(0x3000))
+
+	// INY, RTI then carry on after the WAI
+	cpu.Step()
+	cpu.Step()
+	cpu.Step()
+	expect8(t, cpu.y, newUint8(0x01))
+	expect8(t, cpu.x, newUint8(0x01))
+}
+
+func TestWAIMasked(t *testing.T) {
+	// SEI, WAI, INX
+	cpu := setupInterrupts([]uint8{0x78, 0xcb, 0xe8})
+	cpu.SetVariant(WDC65C02)
+
+	cpu.Step()
+	cpu.Step()
+	cpu.IRQ()
+
+	// with interrupts disabled the IRQ wakes the cpu without being taken
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x03))
+	expect8(t, cpu.x, newUint8(0x01))
+	expect8(t, cpu.y, newUint8(0x00))
+}
+
+func TestSTP(t *testing.T) {
+	// INX, STP, INX
+	cpu := setupInterrupts([]uint8{0xe8, 0xdb, 0xe8})
+	cpu.SetVariant(WDC65C02)
+
+	cpu.Step()
+	cpu.Step()
+	if cpu.Halt() != HaltStopped {
+		t.Fatalf("expected %s got %s", HaltStopped, cpu.Halt())
+	}
+
+	// interrupts do not start it again
+	cpu.IRQ()
+	cpu.NMI()
+	cpu.Step()
+	if cpu.Halt() != HaltStopped {
+		t.Fatalf("expected %s got %s", HaltStopped, cpu.Halt())
+	}
+	expect16(t, cpu.pc, newUint16(ProgramStart+0x02))
+
+	// only a reset does
+	cpu.ResetCPU()
+	expect8(t, uint8(cpu.Halt()), newUint8(uint8(Continue)))
+	expect16(t, cpu.pc, newUint16(ProgramStart))
+	cpu.Step()
+	expect8(t, cpu.x, newUint8(0x01))
+}
+
+func TestWAISTPNotOnOtherVariants(t *testing.T) {
+	for _, variant := range []Variant{NMOS6502, CMOS65C02} {
+		cpu := NewMOS6502()
+		cpu.SetVariant(variant)
+		for _, opcode := range []uint8{0xcb, 0xdb} {
+			if instruction := cpu.instructions[opcode]; instruction != nil {
+				t.Errorf("expected %02x to be unknown on variant %d got %s", opcode, variant, instruction.opc)
+			}
+		}
+	}
+}
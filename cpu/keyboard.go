@@ -0,0 +1,81 @@
+package cpu
+
+// Apple I style keyboard registers
+const (
+	// reading returns the latest key and clears the ready bit
+	KeyboardData uint16 = 0xd010
+	// bit 7 is set when a key is ready to be read
+	KeyboardStatus uint16 = 0xd011
+)
+
+// status bit set when a key is waiting in the data register
+const KeyboardReady uint8 = 0x80
+
+// Keyboard is a memory mapped keyboard fed by a channel of key presses.
+// Any address other than the keyboard registers is passed to the
+// underlying bus
+type Keyboard struct {
+	bus  Bus
+	keys <-chan uint8
+
+	// latest key and if it has been read yet
+	key   uint8
+	ready bool
+}
+
+func NewKeyboard(bus Bus, keys <-chan uint8) *Keyboard {
+	return &Keyboard{
+		bus:  bus,
+		keys: keys,
+	}
+}
+
+// latch the next key if the last one has been read
+func (k *Keyboard) poll() {
+	if k.ready {
+		return
+	}
+
+	select {
+	case key, ok := <-k.keys:
+		if ok {
+			k.key = key
+			k.ready = true
+		}
+	default:
+	}
+}
+
+func (k *Keyboard) Read(address uint16) uint8 {
+	switch address {
+	case KeyboardData:
+		k.poll()
+		k.ready = false
+		return k.key
+
+	case KeyboardStatus:
+		k.poll()
+		if k.ready {
+			return KeyboardReady
+		}
+		return 0x00
+	}
+
+	return k.bus.Read(address)
+}
+
+func (k *Keyboard) Peek(address uint16) (uint8, bool) {
+	if address == KeyboardData || address == KeyboardStatus {
+		return 0, false
+	}
+	return peekBus(k.bus, address)
+}
+
+func (k *Keyboard) Write(address uint16, value uint8) {
+	// the keyboard registers are read only
+	if address == KeyboardData || address == KeyboardStatus {
+		return
+	}
+
+	k.bus.Write(address, value)
+}
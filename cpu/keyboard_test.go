@@ -0,0 +1,61 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestKeyboard(t *testing.T) {
+	cpu := setup([]uint8{
+		0xad, 0x11, 0xd0, // LDA $D011
+		0xad, 0x10, 0xd0, // LDA $D010
+		0x85, 0x00, // STA $00
+		0xad, 0x11, 0xd0, // LDA $D011
+		0xad, 0x10, 0xd0, // LDA $D010
+		0x85, 0x01, // STA $01
+		0xad, 0x11, 0xd0, // LDA $D011
+	}, nil)
+
+	keys := make(chan uint8, 2)
+	keys <- 'A'
+	keys <- 'B'
+
+	cpu.SetBus(NewKeyboard(cpu.memory, keys))
+
+	// first key is ready
+	cpu.Step()
+	expect8(t, cpu.a, newUint8(KeyboardReady))
+	expectFlag(t, cpu, P_Negative, true)
+
+	// read it and store it
+	cpu.Step()
+	cpu.Step()
+	expect8(t, cpu.memory[0x00], newUint8('A'))
+
+	// second key is ready
+	cpu.Step()
+	expect8(t, cpu.a, newUint8(KeyboardReady))
+
+	cpu.Step()
+	cpu.Step()
+	expect8(t, cpu.memory[0x01], newUint8('B'))
+
+	// no more keys
+	cpu.Step()
+	expect8(t, cpu.a, newUint8(0x00))
+	expectFlag(t, cpu, P_Zero, true)
+}
+
+func TestKeyboardReadOnly(t *testing.T) {
+	memory := &Memory{}
+	keyboard := NewKeyboard(memory, make(chan uint8))
+
+	keyboard.Write(KeyboardData, 0x42)
+	keyboard.Write(0x0200, 0x42)
+
+	if memory[KeyboardData] != 0x00 {
+		t.Errorf("expected keyboard data register to not be written to memory")
+	}
+	if memory[0x0200] != 0x42 {
+		t.Errorf("expected write to be passed through to memory got %02x", memory[0x0200])
+	}
+}
@@ -1,5 +1,10 @@
 package cpu
 
+import (
+	"fmt"
+	"strings"
+)
+
 /*
 65k of memory, 256 pages with
 256 bytes per page
@@ -31,6 +36,32 @@ upper half is ROM
 */
 type Memory [0x100 * 0x100]uint8
 
+// NewMemory returns a blank 64k of memory
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// NewMemoryWithROM returns memory with rom copied in at address, erroring
+// if it would run past the end of memory rather than wrapping
+func NewMemoryWithROM(rom []uint8, at uint16) (*Memory, error) {
+	m := NewMemory()
+	if int(at)+len(rom) > len(m) {
+		return nil, fmt.Errorf("%w: %d bytes does not fit at %04x", ErrROMTooLarge, len(rom), at)
+	}
+	copy(m[at:], rom)
+	return m, nil
+}
+
+// Word joins the low and high bytes of a little endian word
+func Word(lo, hi uint8) uint16 {
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+// Bytes splits a word in to its low and high bytes
+func Bytes(w uint16) (lo, hi uint8) {
+	return uint8(w), uint8(w >> 8)
+}
+
 func (m *Memory) Read(address uint16) uint8 {
 	// reads a 1 byte address
 	return m[address]
@@ -38,5 +69,61 @@ func (m *Memory) Read(address uint16) uint8 {
 
 func (m *Memory) ReadWord(address uint16) uint16 {
 	// takes a 2 byte address and returns a 2 byte address
-	return uint16(m[address]) + (uint16(m[address+1]) << 8)
+	return Word(m[address], m[address+1])
+}
+
+func (m *Memory) Write(address uint16, value uint8) {
+	// writes a 1 byte value to the address
+	m[address] = value
+}
+
+func (m *Memory) Peek(address uint16) (uint8, bool) {
+	return m[address], true
+}
+
+// ReadBlock copies len(dst) bytes starting at start in to dst, wrapping
+// around to $0000 if the block runs past the end of memory
+func (m *Memory) ReadBlock(start uint16, dst []uint8) {
+	for len(dst) > 0 {
+		n := copy(dst, m[start:])
+		dst = dst[n:]
+		start += uint16(n)
+	}
+}
+
+// HexDump formats start to end (inclusive) as 16 bytes per line with the
+// address, hex bytes and printable ASCII, i.e.
+//
+//	0400  48 49 21 00 00 00 00 00  00 00 00 00 00 00 00 00  |HI!.............|
+func (m *Memory) HexDump(start, end uint16) string {
+	b := &strings.Builder{}
+
+	for line := int(start); line <= int(end); line += 16 {
+		fmt.Fprintf(b, "%04x ", line)
+
+		ascii := make([]byte, 0, 16)
+		for i := 0; i < 16; i++ {
+			if i == 8 {
+				b.WriteString(" ")
+			}
+
+			address := line + i
+			if address > int(end) {
+				b.WriteString("   ")
+				continue
+			}
+
+			value := m[address]
+			fmt.Fprintf(b, " %02x", value)
+
+			if value < 0x20 || value > 0x7e {
+				value = '.'
+			}
+			ascii = append(ascii, value)
+		}
+
+		fmt.Fprintf(b, "  |%s|\n", ascii)
+	}
+
+	return b.String()
 }
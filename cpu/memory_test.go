@@ -0,0 +1,138 @@
+package cpu
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewMemoryWithROM(t *testing.T) {
+	m := NewMemory()
+	if m.Read(0x1234) != 0 {
+		t.Error("expected new memory to be blank")
+	}
+
+	m, err := NewMemoryWithROM([]uint8{0x01, 0x02, 0x03}, 0xfffd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect8(t, m[0xfffc], newUint8(0x00))
+	expect8(t, m[0xfffd], newUint8(0x01))
+	expect8(t, m[0xffff], newUint8(0x03))
+
+	// a full 64k image fits at $0000
+	if _, err := NewMemoryWithROM(make([]uint8, 0x10000), 0x0000); err != nil {
+		t.Errorf("expected a 64k ROM to fit got %s", err)
+	}
+
+	for _, tc := range []struct {
+		size int
+		at   uint16
+	}{
+		{0x10001, 0x0000},
+		{4, 0xfffd},
+	} {
+		m, err := NewMemoryWithROM(make([]uint8, tc.size), tc.at)
+		if !errors.Is(err, ErrROMTooLarge) || m != nil {
+			t.Errorf("expected %s for %d bytes at %04x got %v", ErrROMTooLarge, tc.size, tc.at, err)
+		}
+	}
+}
+
+func TestReadBlock(t *testing.T) {
+	memory := &Memory{}
+	for i := 0; i < 0x100; i++ {
+		memory[0x0400+i] = uint8(i)
+	}
+
+	page := make([]uint8, 0x100)
+	memory.ReadBlock(0x0400, page)
+
+	for i, b := range page {
+		if b != uint8(i) {
+			t.Fatalf("expected %02x at %d got %02x", uint8(i), i, b)
+		}
+	}
+}
+
+func TestReadBlockWrap(t *testing.T) {
+	memory := &Memory{}
+	memory[0xfffe] = 0x01
+	memory[0xffff] = 0x02
+	memory[0x0000] = 0x03
+	memory[0x0001] = 0x04
+
+	dst := make([]uint8, 4)
+	memory.ReadBlock(0xfffe, dst)
+
+	if !bytes.Equal(dst, []uint8{0x01, 0x02, 0x03, 0x04}) {
+		t.Errorf("expected 01 02 03 04 got % x", dst)
+	}
+}
+
+func TestWordBytes(t *testing.T) {
+	for _, w := range []uint16{0x0000, 0x00ff, 0x0100, 0x1234, 0xff00, 0xfffe, 0xffff} {
+		lo, hi := Bytes(w)
+		if Word(lo, hi) != w {
+			t.Errorf("%04x: round trip gave %04x", w, Word(lo, hi))
+		}
+	}
+
+	lo, hi := Bytes(0x1234)
+	expect8(t, lo, newUint8(0x34))
+	expect8(t, hi, newUint8(0x12))
+
+	lo, hi = Bytes(0xffff)
+	expect8(t, lo, newUint8(0xff))
+	expect8(t, hi, newUint8(0xff))
+
+	expect16(t, Word(0xff, 0xff), newUint16(0xffff))
+	expect16(t, Word(0x00, 0x01), newUint16(0x0100))
+}
+
+func TestHexDump(t *testing.T) {
+	memory := &Memory{}
+	copy(memory[0x0400:], "Hello, 6502!")
+	memory[0x040c] = 0xff
+	memory[0x0410] = 0x7e
+
+	expected := "" +
+		"0400  48 65 6c 6c 6f 2c 20 36  35 30 32 21 ff 00 00 00  |Hello, 6502!....|\n" +
+		"0410  7e 00 00                                          |~..|\n"
+
+	if dump := memory.HexDump(0x0400, 0x0412); dump != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, dump)
+	}
+
+	// the last line of memory does not wrap
+	if dump := memory.HexDump(0xfff0, 0xffff); strings.Count(dump, "\n") != 1 {
+		t.Errorf("expected a single line got\n%s", dump)
+	}
+}
+
+func TestReadWordLittleEndian(t *testing.T) {
+	memory := &Memory{}
+	memory[0x10] = 0x34
+	memory[0x11] = 0x12
+
+	// low byte first
+	expect16(t, memory.ReadWord(0x10), newUint16(0x1234))
+
+	// and the same through the cpu
+	cpu := NewMOS6502()
+	cpu.Reset(memory)
+	expect16(t, cpu.readWord(0x10), newUint16(0x1234))
+	expect16(t, cpu.readZeroPageWord(0x10), newUint16(0x1234))
+}
+
+func TestPushWordOrder(t *testing.T) {
+	// JSR $1234 at $dd00 pushes the return address $dd02 high byte first so
+	// it sits little endian on the stack which grows down
+	cpu := setup([]uint8{0x20, 0x34, 0x12}, nil)
+	cpu.Step()
+
+	expect8(t, cpu.memory[0x01fd], newUint8(0xdd))
+	expect8(t, cpu.memory[0x01fc], newUint8(0x02))
+	expect16(t, cpu.readWord(stackAddress(cpu.sp+1)), newUint16(0xdd02))
+}
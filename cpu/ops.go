@@ -3,7 +3,11 @@ package cpu
 func (cpu *MOS6502) adc(ins *instruction, data uint16) {
 	// Add Memory to Accumulator with Carry
 	// A + M + C -> A, C
-	m := cpu.memory.Read(data)
+	m := cpu.read(data)
+	if cpu.p.isSet(P_Decimal) {
+		cpu.addDecimal(m)
+		return
+	}
 	cpu.addBinary(m)
 }
 
@@ -28,40 +32,123 @@ func (cpu *MOS6502) addBinary(m uint8) {
 
 	// set and test A
 	cpu.a = sum8
-	cpu.testAndSetNegative(cpu.a)
-	cpu.testAndSetZero(cpu.a)
+	cpu.SetNZ(cpu.a)
+}
+
+// addDecimal adds m to A as two BCD digits. the NMOS 6502 sets N, V and Z
+// from the intermediate binary values, the 65C02 fixes N and Z to match the
+// result at the cost of an extra cycle
+func (cpu *MOS6502) addDecimal(m uint8) {
+	a := cpu.a
+
+	var c int = 0
+	if cpu.p.isSet(P_Carry) {
+		c = 1
+	}
+
+	// low digit with the half carry folded in to the high digit
+	lo := int(a&0x0f) + int(m&0x0f) + c
+	if lo >= 0x0a {
+		lo = ((lo + 0x06) & 0x0f) + 0x10
+	}
+
+	// N and V come from the sum before the high digit is adjusted, using
+	// signed arithmetic
+	signed := int(int8(a&0xf0)) + int(int8(m&0xf0)) + lo
+	cpu.p.set(P_Overflow, signed < -128 || signed > 127)
+
+	sum := int(a&0xf0) + int(m&0xf0) + lo
+	if sum >= 0xa0 {
+		sum += 0x60
+	}
+	cpu.p.set(P_Carry, sum >= 0x100)
+	cpu.a = uint8(sum)
+
+	if cpu.cmos() {
+		cpu.SetNZ(cpu.a)
+		cpu.additionalCycles++
+		return
+	}
+
+	cpu.testAndSetNegative(uint8(signed))
+	cpu.testAndSetZero(a + m + uint8(c))
+}
+
+// subDecimal subtracts m from A as two BCD digits. the NMOS 6502 sets every
+// flag as binary subtraction would, the 65C02 sets N and Z from the result
+// and takes an extra cycle
+func (cpu *MOS6502) subDecimal(m uint8) {
+	a := cpu.a
+	borrow := 1
+	if cpu.p.isSet(P_Carry) {
+		borrow = 0
+	}
+
+	lo := int(a&0x0f) - int(m&0x0f) - borrow
+
+	var diff int
+	if cpu.cmos() {
+		diff = int(a) - int(m) - borrow
+		if diff < 0 {
+			diff -= 0x60
+		}
+		if lo < 0 {
+			diff -= 0x06
+		}
+	} else {
+		if lo < 0 {
+			lo = ((lo - 0x06) & 0x0f) - 0x10
+		}
+		diff = int(a&0xf0) - int(m&0xf0) + lo
+		if diff < 0 {
+			diff -= 0x60
+		}
+	}
+
+	// carry and overflow always match binary subtraction
+	cpu.addBinary(^m)
+	cpu.a = uint8(diff)
+
+	if cpu.cmos() {
+		cpu.SetNZ(cpu.a)
+		cpu.additionalCycles++
+	}
 }
 
 func (cpu *MOS6502) and(ins *instruction, data uint16) {
 	// And Memory with Accumulator
-	b := cpu.memory.Read(data)
+	b := cpu.read(data)
 	cpu.a = cpu.a & b
-	cpu.testAndSetNegative(cpu.a)
-	cpu.testAndSetZero(cpu.a)
+	cpu.SetNZ(cpu.a)
 }
 
 func (cpu *MOS6502) asl(ins *instruction, data uint16) {
 	// Shift Left One Bit (Memory or Accumulator)
+	cpu.rmw(ins, data, func(value uint8) (uint8, bool) {
+		return value << 1, value&0x80 == 0x80
+	})
+}
+
+// read-modify-write on the accumulator or memory depending on the address
+// mode. op returns the new value and carry, N and Z are set from the result
+func (cpu *MOS6502) rmw(ins *instruction, data uint16, op func(uint8) (uint8, bool)) {
 	accumulator := ins.mode == AM_ACCUMULATOR
 
-	// if we are immediate get from the accumulator
 	value := cpu.a
 	if !accumulator {
-		value = cpu.memory.Read(data)
+		value = cpu.read(data)
 	}
 
-	// shift right
-	shifted := uint16(value) << 1
+	result, carry := op(value)
 
 	if accumulator {
-		cpu.a = uint8(shifted)
+		cpu.a = result
 	} else {
-		cpu.memory[data] = uint8(shifted)
+		cpu.write(data, result)
 	}
 
-	cpu.testAndSetNegative(uint8(shifted))
-	cpu.testAndSetZero(uint8(shifted))
-	cpu.testAndSetCarry(shifted)
+	cpu.p.set(P_Carry, carry)
+	cpu.SetNZ(result)
 }
 
 func (cpu *MOS6502) bcc(ins *instruction, data uint16) {
@@ -93,10 +180,15 @@ func (cpu *MOS6502) bit(ins *instruction, data uint16) {
 	// bits 7 and 6 of operand are transfered to bit 7 and 6 of SR (N,V);
 	// the zero-flag is set to the result of operand AND accumulator.
 
-	value := cpu.memory.Read(data)
+	value := cpu.read(data)
 
 	cpu.testAndSetZero(cpu.a & value)
 
+	// the 65C02 BIT #imm only sets Z
+	if ins.mode == AM_IMMEDIATE {
+		return
+	}
+
 	// check if 8th bit is set
 	cpu.p.set(P_Negative, value&(1<<7) != 0)
 	// check if 7th bit is set
@@ -147,25 +239,12 @@ func (cpu *MOS6502) brk(ins *instruction, data uint16) {
 	// a 2 byte instruction and can replace it
 	cpu.pc++
 
-	// Force Break
-	// push return data to stack
-	cpu.push(uint8(cpu.pc >> 8))
-	cpu.push(uint8(cpu.pc & 0xff))
-
-	// push status register to stack with break flag and bit 5 set
-	p := cpu.p
-	p.set(P_Break, true)
-	p.set(P_Reserved, true)
-	cpu.push(uint8(p))
-
-	// set intterupt disable
-	cpu.p.set(P_InterruptDisable, true)
-
-	// push interrupt vector to pc
-	hi := uint16(cpu.memory.Read(IRQVectorHigh)) << 8
-	lo := uint16(cpu.memory.Read(IRQVectorLow))
+	if cpu.OnBRK != nil && cpu.OnBRK(cpu) {
+		return
+	}
 
-	cpu.pc = uint16(lo | hi)
+	// Force Break
+	cpu.interrupt(IRQVectorLow, true)
 }
 
 func (cpu *MOS6502) bvc(ins *instruction, data uint16) {
@@ -206,96 +285,98 @@ func (cpu *MOS6502) clv(ins *instruction, data uint16) {
 
 func (cpu *MOS6502) cmp(ins *instruction, data uint16) {
 	// Compare Memory with Accumulator
-	b := cpu.memory.Read(data)
+	b := cpu.read(data)
 
 	// check if the memory is less than the accumulator
 	sub := cpu.a - b
 
 	cpu.p.set(P_Carry, cpu.a >= b)
-	cpu.testAndSetNegative(sub)
-	cpu.testAndSetZero(sub)
+	cpu.SetNZ(sub)
 }
 
 func (cpu *MOS6502) cpx(ins *instruction, data uint16) {
 	// Compare Memory with Accumulator
-	b := cpu.memory.Read(data)
+	b := cpu.read(data)
 
 	// check if the memory is less than the accumulator
 	sub := cpu.x - b
 
 	cpu.p.set(P_Carry, cpu.x >= b)
 
-	cpu.testAndSetNegative(sub)
-	cpu.testAndSetZero(sub)
+	cpu.SetNZ(sub)
 }
 
 func (cpu *MOS6502) cpy(ins *instruction, data uint16) {
 	// Compare Memory with Accumulator
-	b := cpu.memory.Read(data)
+	b := cpu.read(data)
 
 	// check if the memory is less than the accumulator
 	sub := cpu.y - b
 
 	cpu.p.set(P_Carry, cpu.y >= b)
 
-	cpu.testAndSetNegative(sub)
-	cpu.testAndSetZero(sub)
+	cpu.SetNZ(sub)
 }
 
 func (cpu *MOS6502) dec(ins *instruction, data uint16) {
 	// Decrement Memory by One
-	b := cpu.memory.Read(data)
+	b := cpu.read(data)
 	b = b - 1
-	cpu.memory[data] = b
+	cpu.write(data, b)
 
-	cpu.testAndSetNegative(b)
-	cpu.testAndSetZero(b)
+	cpu.SetNZ(b)
+}
+
+func (cpu *MOS6502) deca(ins *instruction, data uint16) {
+	// Decrement Accumulator by One (65C02)
+	cpu.a--
+	cpu.SetNZ(cpu.a)
 }
 
 func (cpu *MOS6502) dex(ins *instruction, data uint16) {
 	// Decrement Index X by One
 	// wrapping is handled by go uint
 	cpu.x--
-	cpu.testAndSetNegative(cpu.x)
-	cpu.testAndSetZero(cpu.x)
+	cpu.SetNZ(cpu.x)
 }
 
 func (cpu *MOS6502) dey(ins *instruction, data uint16) {
 	// Decrement Index Y by One
 	// wrapping is handled by go uint
 	cpu.y--
-	cpu.testAndSetNegative(cpu.y)
-	cpu.testAndSetZero(cpu.y)
+	cpu.SetNZ(cpu.y)
 }
 
 func (cpu *MOS6502) eor(ins *instruction, data uint16) {
 	// Exclusive-OR Memory with Accumulator
-	value := cpu.memory.Read(data)
+	value := cpu.read(data)
 	cpu.a = cpu.a ^ value
-	cpu.testAndSetNegative(cpu.a)
-	cpu.testAndSetZero(cpu.a)
+	cpu.SetNZ(cpu.a)
 }
 
 func (cpu *MOS6502) inx(ins *instruction, data uint16) {
 	// Increment Index X by One
 	cpu.x++
-	cpu.testAndSetNegative(cpu.x)
-	cpu.testAndSetZero(cpu.x)
+	cpu.SetNZ(cpu.x)
 }
 
 func (cpu *MOS6502) iny(ins *instruction, data uint16) {
 	// Increment Index Y by One
 	cpu.y++
-	cpu.testAndSetNegative(cpu.y)
-	cpu.testAndSetZero(cpu.y)
+	cpu.SetNZ(cpu.y)
 }
 
 func (cpu *MOS6502) inc(ins *instruction, data uint16) {
 	// Increment Memory by One
-	value := cpu.memory.Read(data) + 1
-	cpu.memory[data] = value
-	cpu.testAndSetNegative(value)
-	cpu.testAndSetZero(value)
+	value := cpu.read(data) + 1
+	cpu.write(data, value)
+	cpu.SetNZ(value)
+}
+
+func (cpu *MOS6502) inca(ins *instruction, data uint16) {
+	// Increment Accumulator by One (65C02)
+	cpu.a++
+	cpu.SetNZ(cpu.a)
 }
 
 func (cpu *MOS6502) jmp(ins *instruction, data uint16) {
@@ -308,8 +389,7 @@ func (cpu *MOS6502) jsr(ins *instruction, data uint16) {
 	pc := cpu.pc - 1
 
 	// push the lo then the hi bytes on to the stack
-	hi := uint8(pc >> 8)
-	lo := uint8(pc)
+	lo, hi := Bytes(pc)
 
 	cpu.push(hi)
 	cpu.push(lo)
@@ -319,63 +399,46 @@ func (cpu *MOS6502) jsr(ins *instruction, data uint16) {
 
 func (cpu *MOS6502) lda(ins *instruction, data uint16) {
 	// Load Accumulator with Memory
-	value := cpu.memory.Read(data)
+	value := cpu.read(data)
 	cpu.a = value
-	cpu.testAndSetNegative(cpu.a)
-	cpu.testAndSetZero(cpu.a)
+	cpu.SetNZ(cpu.a)
 }
 
 func (cpu *MOS6502) ldx(ins *instruction, data uint16) {
 	// Load Index X with Memory
-	value := cpu.memory.Read(data)
+	value := cpu.read(data)
 	cpu.x = value
-	cpu.testAndSetNegative(cpu.x)
-	cpu.testAndSetZero(cpu.x)
+	cpu.SetNZ(cpu.x)
 }
 
 func (cpu *MOS6502) ldy(ins *instruction, data uint16) {
 	// Load Index X with Memory
-	value := cpu.memory.Read(data)
+	value := cpu.read(data)
 	cpu.y = value
-	cpu.testAndSetNegative(cpu.y)
-	cpu.testAndSetZero(cpu.y)
+	cpu.SetNZ(cpu.y)
 }
 
 func (cpu *MOS6502) lsr(ins *instruction, data uint16) {
 	// Shift One Bit Right (Memory or Accumulator)
-	accumulator := ins.mode == AM_ACCUMULATOR
-
-	// if we are immediate get from the accumulator
-	value := cpu.a
-	if !accumulator {
-		value = cpu.memory.Read(data)
-	}
-
-	// shift right
-	shifted := uint16(value) >> 1
-
-	if accumulator {
-		cpu.a = uint8(shifted)
-	} else {
-		cpu.memory[data] = uint8(shifted)
-	}
-
-	cpu.testAndSetZero(uint8(shifted))
-	cpu.p.set(P_Carry, (value&0x1) == 0x1)
-	cpu.p.set(P_Negative, false)
+	cpu.rmw(ins, data, func(value uint8) (uint8, bool) {
+		return value >> 1, value&0x01 == 0x01
+	})
 }
 
 func (cpu *MOS6502) nop(ins *instruction, data uint16) {
 	// No Operation
+	// undocumented variants still read their operand
+	if ins.mode != AM_IMPLIED {
+		cpu.read(data)
+	}
 }
 
 func (cpu *MOS6502) ora(ins *instruction, data uint16) {
 	// Or Memory with Accumulator
-	value := cpu.memory.Read(data)
+	value := cpu.read(data)
 	cpu.a = cpu.a | value
 
-	cpu.testAndSetNegative(cpu.a)
-	cpu.testAndSetZero(cpu.a)
+	cpu.SetNZ(cpu.a)
 }
 
 func (cpu *MOS6502) pha(ins *instruction, data uint16) {
@@ -387,83 +450,42 @@ func (cpu *MOS6502) php(ins *instruction, data uint16) {
 	// Push Processor Status on Stack
 	// The status register will be pushed with the break
 	// flag and bit 5 set to 1.
-	p := cpu.p
-	p.set(P_Break, true)
-	p.set(P_Reserved, true)
-	cpu.push(uint8(p))
+	cpu.push(cpu.StatusByte())
 }
 
 func (cpu *MOS6502) pla(ins *instruction, data uint16) {
 	// Pull Accumulator from Stack
 	cpu.a = cpu.pop()
-	cpu.testAndSetNegative(cpu.a)
-	cpu.testAndSetZero(cpu.a)
+	cpu.SetNZ(cpu.a)
 }
 
 func (cpu *MOS6502) plp(ins *instruction, data uint16) {
 	// Pull Processor Status from Stack
-	p := flags(cpu.pop())
-	p.set(P_Break, false)
-	p.set(P_Reserved, true)
-	cpu.p = p
+	cpu.SetStatus(cpu.pop())
 }
 
 func (cpu *MOS6502) rol(ins *instruction, data uint16) {
 	// Rotate One Bit Left (Memory or Accumulator)
-	accumulator := ins.mode == AM_ACCUMULATOR
-
-	// if we are immediate get from the accumulator
-	value := cpu.a
-	if !accumulator {
-		value = cpu.memory.Read(data)
-	}
-
-	var c uint8 = 0
+	var c uint8
 	if cpu.p.isSet(P_Carry) {
 		c = 1
 	}
 
-	// roll left
-	rolled := (uint16(value) << 1) | uint16(c)
-
-	if accumulator {
-		cpu.a = uint8(rolled)
-	} else {
-		cpu.memory[data] = uint8(rolled)
-	}
-
-	cpu.p.set(P_Carry, value&0x80 == 0x80)
-	cpu.testAndSetNegative(uint8(rolled))
-	cpu.testAndSetZero(uint8(rolled))
+	cpu.rmw(ins, data, func(value uint8) (uint8, bool) {
+		return value<<1 | c, value&0x80 == 0x80
+	})
 }
 
 func (cpu *MOS6502) ror(ins *instruction, data uint16) {
 	// Rotate One Bit Right (Memory or Accumulator)
-	accumulator := ins.mode == AM_ACCUMULATOR
-
-	// if we are immediate get from the accumulator
-	value := cpu.a
-	if !accumulator {
-		value = cpu.memory.Read(data)
-	}
-
-	var c uint8 = 0
+	var c uint8
 	if cpu.p.isSet(P_Carry) {
 		c = 1
 	}
 
-	// roll right
-	rolled := uint16(value)>>1 | uint16(c)<<7
-
-	if accumulator {
-		cpu.a = uint8(rolled)
-	} else {
-		cpu.memory[data] = uint8(rolled)
-	}
-
-	cpu.p.set(P_Carry, value&0x01 == 0x01)
-	cpu.testAndSetNegative(uint8(rolled))
-	cpu.testAndSetZero(uint8(rolled))
+	cpu.rmw(ins, data, func(value uint8) (uint8, bool) {
+		return value>>1 | c<<7, value&0x01 == 0x01
+	})
 }
 
 func (cpu *MOS6502) rti(ins *instruction, data uint16) {
@@ -479,7 +501,7 @@ func (cpu *MOS6502) rti(ins *instruction, data uint16) {
 	lo := cpu.pop()
 	hi := cpu.pop()
 
-	cpu.pc = uint16(hi)<<8 | uint16(lo)
+	cpu.pc = Word(lo, hi)
 }
 
 func (cpu *MOS6502) rts(ins *instruction, data uint16) {
@@ -488,12 +510,16 @@ func (cpu *MOS6502) rts(ins *instruction, data uint16) {
 	lo := cpu.pop()
 	hi := cpu.pop()
 
-	cpu.pc = (uint16(lo) | (uint16(hi) << 8))
+	cpu.pc = Word(lo, hi)
 	cpu.pc++ // Increment the program counter by 1
 }
 
 func (cpu *MOS6502) sbc(ins *instruction, data uint16) {
-	m := cpu.memory.Read(data)
+	m := cpu.read(data)
+	if cpu.p.isSet(P_Decimal) {
+		cpu.subDecimal(m)
+		return
+	}
 	cpu.addBinary(^m)
 }
 
@@ -514,45 +540,47 @@ func (cpu *MOS6502) sei(ins *instruction, data uint16) {
 
 func (cpu *MOS6502) sta(ins *instruction, data uint16) {
 	// Store Accumulator in Memory
-	cpu.memory[data] = cpu.a
+	cpu.write(data, cpu.a)
+}
+
+func (cpu *MOS6502) stp(ins *instruction, data uint16) {
+	// Stop the Clock
+	// only a reset starts it again
+	cpu.halt = HaltStopped
 }
 
 func (cpu *MOS6502) stx(ins *instruction, data uint16) {
 	// Store Index X in Memory
-	cpu.memory[data] = cpu.x
+	cpu.write(data, cpu.x)
 }
 
 func (cpu *MOS6502) sty(ins *instruction, data uint16) {
 	// Store Index Y in Memory
-	cpu.memory[data] = cpu.y
+	cpu.write(data, cpu.y)
 }
 
 func (cpu *MOS6502) tax(ins *instruction, data uint16) {
 	// Transfer Accumulator to Index X
 	cpu.x = cpu.a
-	cpu.testAndSetNegative(cpu.x)
-	cpu.testAndSetZero(cpu.x)
+	cpu.SetNZ(cpu.x)
 }
 
 func (cpu *MOS6502) tay(ins *instruction, data uint16) {
 	// Transfer Accumulator to Index Y
 	cpu.y = cpu.a
-	cpu.testAndSetNegative(cpu.y)
-	cpu.testAndSetZero(cpu.y)
+	cpu.SetNZ(cpu.y)
 }
 
 func (cpu *MOS6502) tsx(ins *instruction, data uint16) {
 	// Transfer Stack Pointer to Index X
 	cpu.x = uint8(cpu.sp)
-	cpu.testAndSetNegative(cpu.x)
-	cpu.testAndSetZero(cpu.x)
+	cpu.SetNZ(cpu.x)
 }
 
 func (cpu *MOS6502) txa(ins *instruction, data uint16) {
 	// Transfer Index X to Accumulator
 	cpu.a = cpu.x
-	cpu.testAndSetNegative(cpu.a)
-	cpu.testAndSetZero(cpu.a)
+	cpu.SetNZ(cpu.a)
 }
 
 func (cpu *MOS6502) txs(ins *instruction, data uint16) {
@@ -563,6 +591,12 @@ func (cpu *MOS6502) txs(ins *instruction, data uint16) {
 func (cpu *MOS6502) tya(ins *instruction, data uint16) {
 	// Transfer Index Y to Accumulator
 	cpu.a = cpu.y
-	cpu.testAndSetNegative(cpu.a)
-	cpu.testAndSetZero(cpu.a)
+	cpu.SetNZ(cpu.a)
+}
+
+func (cpu *MOS6502) wai(ins *instruction, data uint16) {
+	// Wait for Interrupt
+	// an IRQ or NMI wakes the cpu, an IRQ while interrupts are disabled
+	// carries on after the WAI without being taken
+	cpu.halt = HaltWaitingForInterrupt
 }
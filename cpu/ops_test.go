@@ -1,6 +1,7 @@
 package cpu
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -58,10 +59,282 @@ func TestADC(t *testing.T) {
 			expectNegative: false,
 			setupA:         newUint8(0x01),
 		},
+		{
+			name:          "decimal",
+			program:       []uint8{0x69, 0x01},
+			setupA:        newUint8(0x09),
+			setupDecimal:  newBool(true),
+			expectA:       newUint8(0x10),
+			expectDecimal: newBool(true),
+		},
+		{
+			name:           "decimal carry sets N and Z from the binary sum",
+			program:        []uint8{0x69, 0x01},
+			setupA:         newUint8(0x99),
+			setupDecimal:   newBool(true),
+			expectA:        newUint8(0x00),
+			expectCarry:    true,
+			expectNegative: true,
+			expectDecimal:  newBool(true),
+		},
+		{
+			name:          "decimal carry on CMOS sets N and Z from the result",
+			program:       []uint8{0x69, 0x01},
+			variant:       CMOS65C02,
+			setupA:        newUint8(0x99),
+			setupDecimal:  newBool(true),
+			expectA:       newUint8(0x00),
+			expectCarry:   true,
+			expectZero:    true,
+			expectDecimal: newBool(true),
+		},
 	}
 	tests.run(t)
 }
 
+func TestDecimalCycles(t *testing.T) {
+	for _, variant := range []Variant{NMOS6502, CMOS65C02} {
+		for _, opcode := range []uint8{0x69, 0xe9} {
+			var cycles [2]uint64
+			for i, decimal := range []bool{false, true} {
+				cpu := setup([]uint8{opcode, 0x01}, nil)
+				cpu.SetVariant(variant)
+				cpu.p.set(P_Decimal, decimal)
+				cpu.Step()
+				cycles[i] = cpu.TotalCycles
+			}
+
+			// the 65C02 takes an extra cycle to fix up the flags
+			expected := cycles[0]
+			if variant == CMOS65C02 {
+				expected++
+			}
+			if cycles[1] != expected {
+				t.Errorf("%02x on %v: expected decimal to take %d cycles got %d (binary %d)", opcode, variant, expected, cycles[1], cycles[0])
+			}
+		}
+	}
+}
+
+// clarkDecimal is the reference model from Bruce Clark's decimal mode
+// tutorial, returning A, C, Z, V and N for ADC or SBC of m from a
+func clarkDecimal(variant Variant, sbc bool, a, m uint8, c bool) (uint8, [4]bool) {
+	carry := 0
+	if c {
+		carry = 1
+	}
+
+	// binary result gives Z on the NMOS and every flag but A for NMOS SBC
+	bin := int(a) + int(m) + carry
+	binV := (a^uint8(bin))&(m^uint8(bin))&0x80 != 0
+	if sbc {
+		bin = int(a) + int(^m) + carry
+		binV = (a^uint8(bin))&(^m^uint8(bin))&0x80 != 0
+	}
+
+	var result, seq2 int
+	if !sbc {
+		// sequence 1 and 2
+		al := int(a&0x0f) + int(m&0x0f) + carry
+		if al >= 0x0a {
+			al = ((al + 0x06) & 0x0f) + 0x10
+		}
+		seq2 = int(int8(a&0xf0)) + int(int8(m&0xf0)) + al
+		result = int(a&0xf0) + int(m&0xf0) + al
+		if result >= 0xa0 {
+			result += 0x60
+		}
+		n := seq2&0x80 != 0
+		z := uint8(bin) == 0
+		if variant == CMOS65C02 {
+			n, z = result&0x80 != 0, uint8(result) == 0
+		}
+		return uint8(result), [4]bool{result >= 0x100, z, seq2 < -128 || seq2 > 127, n}
+	}
+
+	al := int(a&0x0f) - int(m&0x0f) + carry - 1
+	if variant == CMOS65C02 {
+		// sequence 4
+		result = int(a) - int(m) + carry - 1
+		if result < 0 {
+			result -= 0x60
+		}
+		if al < 0 {
+			result -= 0x06
+		}
+		return uint8(result), [4]bool{bin >= 0x100, uint8(result) == 0, binV, result&0x80 != 0}
+	}
+
+	// sequence 3
+	if al < 0 {
+		al = ((al - 0x06) & 0x0f) - 0x10
+	}
+	result = int(a&0xf0) - int(m&0xf0) + al
+	if result < 0 {
+		result -= 0x60
+	}
+	return uint8(result), [4]bool{bin >= 0x100, uint8(bin) == 0, binV, bin&0x80 != 0}
+}
+
+func TestDecimalFlags(t *testing.T) {
+	// worked examples, many from the tutorial, run on the cpu itself. the
+	// letters are the flags set out of C, Z, V and N. on the NMOS 6502 ADC
+	// takes N and V from the sum before the high digit is adjusted and Z
+	// from the binary sum, SBC sets every flag as binary. the 65C02 takes N
+	// and Z from the result
+	for _, tc := range []struct {
+		opcode     uint8
+		a, m       uint8
+		c          bool
+		result     uint8
+		nmos, cmos string
+	}{
+		{0x69, 0x00, 0x01, true, 0x02, "", ""},
+		{0x69, 0x12, 0x34, false, 0x46, "", ""},
+		{0x69, 0x58, 0x46, true, 0x05, "CVN", "CV"},
+		{0x69, 0x81, 0x92, false, 0x73, "CV", "CV"},
+		{0x69, 0x99, 0x01, false, 0x00, "CN", "CZ"},
+		{0x69, 0x79, 0x00, true, 0x80, "VN", "VN"},
+		{0x69, 0x24, 0x56, false, 0x80, "VN", "VN"},
+		{0x69, 0x93, 0x82, false, 0x75, "CV", "CV"},
+		{0x69, 0x89, 0x76, false, 0x65, "C", "C"},
+		{0x69, 0x89, 0x76, true, 0x66, "CZ", "C"},
+		{0x69, 0x80, 0xf0, false, 0xd0, "CV", "CVN"},
+		{0x69, 0x80, 0xfa, false, 0xe0, "CN", "CN"},
+		{0x69, 0x2f, 0x4f, false, 0x74, "", ""},
+		{0x69, 0x6f, 0x00, true, 0x76, "", ""},
+		{0xe9, 0x46, 0x12, true, 0x34, "C", "C"},
+		{0xe9, 0x40, 0x13, true, 0x27, "C", "C"},
+		{0xe9, 0x32, 0x02, false, 0x29, "C", "C"},
+		{0xe9, 0x12, 0x21, true, 0x91, "N", "N"},
+		{0xe9, 0x21, 0x34, true, 0x87, "N", "N"},
+		{0xe9, 0x00, 0x01, true, 0x99, "N", "N"},
+		{0xe9, 0x80, 0x01, true, 0x79, "CV", "CV"},
+		{0xe9, 0x10, 0x0a, true, 0x00, "C", "CZ"},
+	} {
+		for _, variant := range []Variant{NMOS6502, CMOS65C02} {
+			expected := tc.nmos
+			if variant == CMOS65C02 {
+				expected = tc.cmos
+			}
+
+			cpu := setup([]uint8{tc.opcode, tc.m}, nil)
+			cpu.SetVariant(variant)
+			cpu.a = tc.a
+			cpu.p.set(P_Decimal, true)
+			cpu.p.set(P_Carry, tc.c)
+			cpu.Step()
+
+			var got string
+			for _, flag := range []struct {
+				letter string
+				bit    flag
+			}{{"C", P_Carry}, {"Z", P_Zero}, {"V", P_Overflow}, {"N", P_Negative}} {
+				if cpu.p.isSet(flag.bit) {
+					got += flag.letter
+				}
+			}
+			if cpu.a != tc.result || got != expected {
+				t.Errorf("%02x on %v with A=%02x M=%02x C=%v: expected %02x %q got %02x %q", tc.opcode, variant, tc.a, tc.m, tc.c, tc.result, expected, cpu.a, got)
+			}
+		}
+	}
+
+	// every operand and carry against the reference model as the decimal
+	// test does
+	cpu := setup([]uint8{0x00, 0x00}, nil)
+	for _, variant := range []Variant{NMOS6502, CMOS65C02} {
+		cpu.SetVariant(variant)
+		for _, opcode := range []uint8{0x69, 0xe9} {
+			failures := 0
+			for i := 0; i < 0x20000 && failures < 10; i++ {
+				a, m, c := uint8(i), uint8(i>>8), i&0x10000 != 0
+
+				cpu.memory[ProgramStart] = opcode
+				cpu.memory[ProgramStart+1] = m
+				cpu.pc = ProgramStart
+				cpu.a = a
+				cpu.p.set(P_Decimal, true)
+				cpu.p.set(P_Carry, c)
+				cpu.Step()
+
+				result, flags := clarkDecimal(variant, opcode == 0xe9, a, m, c)
+				got := [4]bool{
+					cpu.p.isSet(P_Carry),
+					cpu.p.isSet(P_Zero),
+					cpu.p.isSet(P_Overflow),
+					cpu.p.isSet(P_Negative),
+				}
+				if cpu.a != result || got != flags {
+					t.Errorf("%02x on %v with A=%02x M=%02x C=%v: expected %02x CZVN %v got %02x %v", opcode, variant, a, m, c, result, flags, cpu.a, got)
+					failures++
+				}
+			}
+		}
+	}
+}
+
+func TestMultiByteArithmetic(t *testing.T) {
+	// operands are little endian at $10 and $20 with the result at $30
+	tests := []struct {
+		name     string
+		setup    string
+		op       string
+		a, b     uint32
+		size     int
+		expected uint32
+		carry    bool
+	}{
+		{"16 bit add", "CLC", "ADC", 0x12ff, 0x0001, 2, 0x1300, false},
+		{"16 bit add carry out", "CLC", "ADC", 0xff00, 0x0100, 2, 0x0000, true},
+		{"24 bit add", "CLC", "ADC", 0x01ffff, 0x000001, 3, 0x020000, false},
+		{"24 bit add carry out", "CLC", "ADC", 0xffffff, 0x000001, 3, 0x000000, true},
+		{"16 bit subtract", "SEC", "SBC", 0x1300, 0x0001, 2, 0x12ff, true},
+		{"16 bit subtract borrow out", "SEC", "SBC", 0x0000, 0x0001, 2, 0xffff, false},
+		{"24 bit subtract", "SEC", "SBC", 0x020000, 0x000001, 3, 0x01ffff, true},
+		{"24 bit subtract borrow out", "SEC", "SBC", 0x000100, 0x010000, 3, 0xff0100, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src := tc.setup + "\n"
+			for i := 0; i < tc.size; i++ {
+				src += fmt.Sprintf("LDA $%02x\n%s $%02x\nSTA $%02x\n", 0x10+i, tc.op, 0x20+i, 0x30+i)
+			}
+			src += "RTS\n"
+
+			program, err := Assemble(src, ProgramStart)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			memory := map[uint16]uint8{}
+			for i := 0; i < tc.size; i++ {
+				memory[uint16(0x10+i)] = uint8(tc.a >> (8 * i))
+				memory[uint16(0x20+i)] = uint8(tc.b >> (8 * i))
+			}
+
+			cpu := setup(program.Bytes, memory)
+			// start with the wrong carry to check the first op sets it up
+			cpu.p.set(P_Carry, tc.setup == "CLC")
+			cpu.pc = 0x0400
+
+			if err := cpu.CallSubroutine(ProgramStart, 1000); err != nil {
+				t.Fatal(err)
+			}
+
+			var result uint32
+			for i := 0; i < tc.size; i++ {
+				result |= uint32(cpu.memory[0x30+i]) << (8 * i)
+			}
+			if result != tc.expected {
+				t.Errorf("expected %06x got %06x", tc.expected, result)
+			}
+			expectFlag(t, cpu, P_Carry, tc.carry)
+		})
+	}
+}
+
 func TestAND(t *testing.T) {
 	tests := testCases{
 		{
@@ -95,6 +368,7 @@ func TestASL(t *testing.T) {
 		{
 			name:        "accumulator",
 			program:     []uint8{0x0a},
+			setupA:      newUint8(0xaa),
 			expectA:     newUint8(0x54),
 			expectCarry: true,
 		},
@@ -109,14 +383,14 @@ func TestASL(t *testing.T) {
 			name:           "zeropage",
 			program:        []uint8{0x06, 0x42},
 			memory:         map[uint16]uint8{0x0042: 0x55},
-			expectMemory:   map[uint16]uint8{0x0042: 0xaa},
+			expectDelta:    map[uint16]uint8{0x0042: 0xaa},
 			expectNegative: true,
 		},
 		{
 			name:           "zeropage,x",
 			program:        []uint8{0x16, 0x42},
 			memory:         map[uint16]uint8{0x0047: 0x55},
-			expectMemory:   map[uint16]uint8{0x0047: 0xaa},
+			expectDelta:    map[uint16]uint8{0x0047: 0xaa},
 			expectNegative: true,
 			setupX:         newUint8(0x5),
 		},
@@ -124,14 +398,14 @@ func TestASL(t *testing.T) {
 			name:           "absolute",
 			program:        []uint8{0x0e, 0x42},
 			memory:         map[uint16]uint8{0x0042: 0x55},
-			expectMemory:   map[uint16]uint8{0x0042: 0xaa},
+			expectDelta:    map[uint16]uint8{0x0042: 0xaa},
 			expectNegative: true,
 		},
 		{
 			name:           "absolute,x",
 			program:        []uint8{0x1e, 0x42},
 			memory:         map[uint16]uint8{0x0047: 0x55},
-			expectMemory:   map[uint16]uint8{0x0047: 0xaa},
+			expectDelta:    map[uint16]uint8{0x0047: 0xaa},
 			expectNegative: true,
 			setupX:         newUint8(0x5),
 		},
@@ -251,6 +525,76 @@ func TestBIT(t *testing.T) {
 			setupA:         newUint8(0xFF),
 			expectOverflow: false,
 		},
+		{
+			name:              "absolute sets Z flag when A & M is zero",
+			program:           []uint8{0x2c, 0x10, 0x12},
+			memory:            map[uint16]uint8{0x1210: 0x0f},
+			setupA:            newUint8(0xf0),
+			expectZero:        true,
+			expectA:           newUint8(0xf0),
+			expectPC:          newUint16(ProgramStart + 3),
+			expectTotalCycles: newUint64(4),
+		},
+		{
+			name:           "absolute N and V come from memory while Z is clear",
+			program:        []uint8{0x2c, 0x10, 0x12},
+			memory:         map[uint16]uint8{0x1210: 0xc1},
+			setupA:         newUint8(0x01),
+			expectZero:     false,
+			expectNegative: true,
+			expectOverflow: true,
+			expectA:        newUint8(0x01),
+		},
+		{
+			name:           "absolute N and V clear from memory even if set in A",
+			program:        []uint8{0x2c, 0x10, 0x12},
+			memory:         map[uint16]uint8{0x1210: 0x3f},
+			setupA:         newUint8(0xff),
+			setupNegative:  newBool(true),
+			setupOverflow:  newBool(true),
+			expectZero:     false,
+			expectNegative: false,
+			expectOverflow: false,
+		},
+		{
+			name:           "absolute N set and V clear from memory",
+			program:        []uint8{0x2c, 0x10, 0x12},
+			memory:         map[uint16]uint8{0x1210: 0x80},
+			setupA:         newUint8(0x7f),
+			expectZero:     true,
+			expectNegative: true,
+			expectOverflow: false,
+		},
+		{
+			name:              "immediate on CMOS only sets Z",
+			program:           []uint8{0x89, 0xc0},
+			variant:           CMOS65C02,
+			setupA:            newUint8(0x3f),
+			expectZero:        true,
+			expectPC:          newUint16(ProgramStart + 2),
+			expectTotalCycles: newUint64(2),
+		},
+		{
+			name:           "immediate on CMOS leaves N and V set",
+			program:        []uint8{0x89, 0x01},
+			variant:        CMOS65C02,
+			setupA:         newUint8(0x01),
+			setupNegative:  newBool(true),
+			setupOverflow:  newBool(true),
+			expectZero:     false,
+			expectNegative: true,
+			expectOverflow: true,
+		},
+		{
+			name:           "zeropage on CMOS still sets N and V from memory",
+			program:        []uint8{0x24, 0x10},
+			variant:        CMOS65C02,
+			memory:         map[uint16]uint8{0x0010: 0xc0},
+			setupA:         newUint8(0x3f),
+			expectZero:     true,
+			expectNegative: true,
+			expectOverflow: true,
+		},
 	}
 	tests.run(t)
 }
@@ -325,11 +669,11 @@ func TestBRK(t *testing.T) {
 				IRQVectorHigh: 0x10,
 			},
 			expectPC: newUint16(0x1010),
-			expectSP: newUint8(StackTop - 0x03), // lo, hi, pc
-			expectMemory: map[uint16]uint8{
-				stackAddress(StackTop):       0xdd, // push PC high byte
-				stackAddress(StackTop - 0x1): 0x02, // push PC low byte
-				stackAddress(StackTop - 0x2): 0x34, // push status with B flag set
+			expectSP: newUint8(StackReset - 0x03), // lo, hi, pc
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset):       0xdd, // push PC high byte
+				stackAddress(StackReset - 0x1): 0x02, // push PC low byte
+				stackAddress(StackReset - 0x2): 0x34, // push status with B flag set
 			},
 			expectBreak:            newBool(true),
 			expectInterruptDisable: newBool(true),
@@ -348,11 +692,11 @@ func TestBRK(t *testing.T) {
 			setupOverflow: newBool(true),
 			setupNegative: newBool(true),
 			expectPC:      newUint16(0x1010),
-			expectSP:      newUint8(StackTop - 0x03),
-			expectMemory: map[uint16]uint8{
-				stackAddress(StackTop):       0xdd,
-				stackAddress(StackTop - 0x1): 0x02,
-				stackAddress(StackTop - 0x2): 0xF7, // All flags set except zero
+			expectSP:      newUint8(StackReset - 0x03),
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset):       0xdd,
+				stackAddress(StackReset - 0x1): 0x02,
+				stackAddress(StackReset - 0x2): 0xF7, // All flags set except zero
 			},
 			expectBreak:            newBool(true),
 			expectInterruptDisable: newBool(true),
@@ -371,19 +715,99 @@ func TestBRK(t *testing.T) {
 				IRQVectorHigh: 0x10,
 			},
 			expectPC: newUint16(0x1010),
-			expectSP: newUint8(StackTop - 0x03),
-			expectMemory: map[uint16]uint8{
-				stackAddress(StackTop):       0xdd,
-				stackAddress(StackTop - 0x1): 0x02,
-				stackAddress(StackTop - 0x2): 0x34, // Only B flag and reserved flag set
+			expectSP: newUint8(StackReset - 0x03),
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset):       0xdd,
+				stackAddress(StackReset - 0x1): 0x02,
+				stackAddress(StackReset - 0x2): 0x34, // Only B flag and reserved flag set
 			},
 			expectBreak:            newBool(true),
 			expectInterruptDisable: newBool(true),
 		},
+		{
+			name: "BRK preserves decimal on NMOS",
+			program: []uint8{
+				0x00, // BRK
+			},
+			memory: map[uint16]uint8{
+				IRQVectorLow:  0x10,
+				IRQVectorHigh: 0x10,
+			},
+			setupDecimal: newBool(true),
+			expectPC:     newUint16(0x1010),
+			expectSP:     newUint8(StackReset - 0x03),
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset):       0xdd,
+				stackAddress(StackReset - 0x1): 0x02,
+				stackAddress(StackReset - 0x2): 0x3c, // B, reserved and decimal set
+			},
+			expectBreak:            newBool(true),
+			expectInterruptDisable: newBool(true),
+			expectDecimal:          newBool(true),
+		},
+		{
+			name: "BRK clears decimal on CMOS",
+			program: []uint8{
+				0x00, // BRK
+			},
+			memory: map[uint16]uint8{
+				IRQVectorLow:  0x10,
+				IRQVectorHigh: 0x10,
+			},
+			variant:      CMOS65C02,
+			setupDecimal: newBool(true),
+			expectPC:     newUint16(0x1010),
+			expectSP:     newUint8(StackReset - 0x03),
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset):       0xdd,
+				stackAddress(StackReset - 0x1): 0x02,
+				stackAddress(StackReset - 0x2): 0x3c, // decimal is pushed before being cleared
+			},
+			expectBreak:            newBool(true),
+			expectInterruptDisable: newBool(true),
+			expectDecimal:          newBool(false),
+		},
 	}
 	tests.run(t)
 }
 
+func TestOnBRK(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x01, // LDA #$01
+		0x00, 0xff, // BRK, signature byte
+		0xa2, 0x05, // LDX #$05
+	}, map[uint16]uint8{
+		IRQVectorLow:  0x00,
+		IRQVectorHigh: 0x30,
+	})
+
+	// syscall 1 returns its result in Y
+	var calls []uint8
+	cpu.OnBRK = func(cpu *MOS6502) bool {
+		calls = append(calls, cpu.a)
+		cpu.y = 0x42
+		return cpu.a == 0x01
+	}
+
+	for i := 0; i < 3; i++ {
+		cpu.Step()
+	}
+
+	if len(calls) != 1 || calls[0] != 0x01 {
+		t.Errorf("expected one call of syscall 1 got %v", calls)
+	}
+	expect8(t, cpu.y, newUint8(0x42))
+	expect8(t, cpu.x, newUint8(0x05))
+	expect8(t, cpu.sp, newUint8(StackReset))
+
+	// not handled takes the interrupt as usual
+	cpu.pc = ProgramStart + 2
+	cpu.a = 0x02
+	cpu.Step()
+	expect16(t, cpu.pc, newUint16(0x3000))
+	expect8(t, cpu.sp, newUint8(StackReset-0x03))
+}
+
 func TestBVC(t *testing.T) {
 	tests := testCases{
 		{
@@ -422,6 +846,52 @@ func TestBVS(t *testing.T) {
 	tests.run(t)
 }
 
+func TestBranchCycles(t *testing.T) {
+	branches := []struct {
+		name   string
+		opcode uint8
+		flag   flag
+		taken  bool
+	}{
+		{"BCC", 0x90, P_Carry, false},
+		{"BCS", 0xb0, P_Carry, true},
+		{"BEQ", 0xf0, P_Zero, true},
+		{"BNE", 0xd0, P_Zero, false},
+		{"BMI", 0x30, P_Negative, true},
+		{"BPL", 0x10, P_Negative, false},
+		{"BVC", 0x50, P_Overflow, false},
+		{"BVS", 0x70, P_Overflow, true},
+	}
+
+	outcomes := []struct {
+		name   string
+		start  uint16
+		offset uint8
+		taken  bool
+		pc     uint16
+		cycles uint64
+	}{
+		{"not taken", 0x1000, 0x10, false, 0x1002, 2},
+		{"taken same page", 0x1000, 0x10, true, 0x1012, 3},
+		{"taken crossing forwards", 0x10f0, 0x10, true, 0x1102, 4},
+		{"taken crossing backwards", 0x1000, 0xf0, true, 0x0ff2, 4},
+	}
+
+	for _, b := range branches {
+		for _, o := range outcomes {
+			t.Run(b.name+" "+o.name, func(t *testing.T) {
+				cpu := setupAt(o.start, []uint8{b.opcode, o.offset}, nil)
+				// set the flag so the branch goes the way we want
+				cpu.p.set(b.flag, b.taken == o.taken)
+				cpu.Step()
+
+				expect16(t, cpu.pc, &o.pc)
+				expect64(t, cpu.TotalCycles, &o.cycles)
+			})
+		}
+	}
+}
+
 func TestCLC(t *testing.T) {
 	tests := testCases{
 		{
@@ -486,6 +956,29 @@ func TestCLV(t *testing.T) {
 			program:        []uint8{0xb8},
 			expectOverflow: false,
 		},
+		{
+			name: "bit sets overflow before clv",
+			program: []uint8{
+				0x24, 0x42, // BIT $42
+				0xb8, // CLV
+			},
+			memory:         map[uint16]uint8{0x0042: 0x40},
+			setupA:         newUint8(0x40),
+			expectOverflow: true,
+			expectPC:       newUint16(ProgramStart + 2),
+		},
+		{
+			name: "clv clears overflow set by bit",
+			program: []uint8{
+				0x24, 0x42, // BIT $42
+				0xb8, // CLV
+			},
+			memory:         map[uint16]uint8{0x0042: 0x40},
+			setupA:         newUint8(0x40),
+			cycles:         3,
+			expectOverflow: false,
+			expectPC:       newUint16(ProgramStart + 3),
+		},
 	}
 	tests.run(t)
 }
@@ -507,7 +1000,7 @@ func TestCMP(t *testing.T) {
 			expectInterruptDisable: nil,
 			expectDecimal:          nil,
 			expectBreak:            nil,
-			expectMemory:           nil,
+			expectDelta:            nil,
 		},
 		{
 			name: "Immediate, greater",
@@ -524,7 +1017,7 @@ func TestCMP(t *testing.T) {
 			expectInterruptDisable: nil,
 			expectDecimal:          nil,
 			expectBreak:            nil,
-			expectMemory:           nil,
+			expectDelta:            nil,
 		},
 		{
 			name: "Immediate, less",
@@ -541,9 +1034,98 @@ func TestCMP(t *testing.T) {
 			expectInterruptDisable: nil,
 			expectDecimal:          nil,
 			expectBreak:            nil,
-			expectMemory:           nil,
+			expectDelta:            nil,
+		},
+		// equal sets C and Z and clears N whatever the addressing mode
+		{
+			name:           "Zeropage, equal",
+			program:        []uint8{0xC5, 0x10},
+			memory:         map[uint16]uint8{0x0010: 0x80},
+			setupA:         newUint8(0x80),
+			setupNegative:  newBool(true),
+			expectA:        newUint8(0x80),
+			expectPC:       newUint16(ProgramStart + 2),
+			expectZero:     true,
+			expectCarry:    true,
+			expectNegative: false,
+		},
+		{
+			name:           "Zeropage,X, equal",
+			program:        []uint8{0xD5, 0x10},
+			memory:         map[uint16]uint8{0x0011: 0x80},
+			setupX:         newUint8(0x01),
+			setupA:         newUint8(0x80),
+			setupNegative:  newBool(true),
+			expectA:        newUint8(0x80),
+			expectPC:       newUint16(ProgramStart + 2),
+			expectZero:     true,
+			expectCarry:    true,
+			expectNegative: false,
+		},
+		{
+			name:           "Absolute, equal",
+			program:        []uint8{0xCD, 0x34, 0x12},
+			memory:         map[uint16]uint8{0x1234: 0x80},
+			setupA:         newUint8(0x80),
+			setupNegative:  newBool(true),
+			expectA:        newUint8(0x80),
+			expectPC:       newUint16(ProgramStart + 3),
+			expectZero:     true,
+			expectCarry:    true,
+			expectNegative: false,
+		},
+		{
+			name:           "Absolute,X, equal",
+			program:        []uint8{0xDD, 0x34, 0x12},
+			memory:         map[uint16]uint8{0x1235: 0x80},
+			setupX:         newUint8(0x01),
+			setupA:         newUint8(0x80),
+			setupNegative:  newBool(true),
+			expectA:        newUint8(0x80),
+			expectPC:       newUint16(ProgramStart + 3),
+			expectZero:     true,
+			expectCarry:    true,
+			expectNegative: false,
+		},
+		{
+			name:           "Absolute,Y, equal",
+			program:        []uint8{0xD9, 0x34, 0x12},
+			memory:         map[uint16]uint8{0x1235: 0x80},
+			setupY:         newUint8(0x01),
+			setupA:         newUint8(0x80),
+			setupNegative:  newBool(true),
+			expectA:        newUint8(0x80),
+			expectPC:       newUint16(ProgramStart + 3),
+			expectZero:     true,
+			expectCarry:    true,
+			expectNegative: false,
+		},
+		{
+			name:           "Indirect,X, equal",
+			program:        []uint8{0xC1, 0x10},
+			memory:         map[uint16]uint8{0x0011: 0x34, 0x0012: 0x12, 0x1234: 0x80},
+			setupX:         newUint8(0x01),
+			setupA:         newUint8(0x80),
+			setupNegative:  newBool(true),
+			expectA:        newUint8(0x80),
+			expectPC:       newUint16(ProgramStart + 2),
+			expectZero:     true,
+			expectCarry:    true,
+			expectNegative: false,
+		},
+		{
+			name:           "Indirect,Y, equal",
+			program:        []uint8{0xD1, 0x10},
+			memory:         map[uint16]uint8{0x0010: 0x34, 0x0011: 0x12, 0x1235: 0x80},
+			setupY:         newUint8(0x01),
+			setupA:         newUint8(0x80),
+			setupNegative:  newBool(true),
+			expectA:        newUint8(0x80),
+			expectPC:       newUint16(ProgramStart + 2),
+			expectZero:     true,
+			expectCarry:    true,
+			expectNegative: false,
 		},
-		// Add more test cases here for other addressing modes and scenarios
 	}
 	tests.run(t)
 }
@@ -728,6 +1310,51 @@ func TestCPY(t *testing.T) {
 	tests.run(t)
 }
 
+func TestCompareOverflow(t *testing.T) {
+	// compares only touch N, Z and C so a set V survives every outcome
+	var tests testCases
+	for _, op := range []struct {
+		name   string
+		opcode uint8
+	}{
+		{"CMP", 0xc9},
+		{"CPX", 0xe0},
+		{"CPY", 0xc0},
+	} {
+		for _, c := range []struct {
+			name     string
+			register uint8
+			carry    bool
+			zero     bool
+			negative bool
+		}{
+			{"less", 0x40, false, false, true},
+			{"equal", 0x42, true, true, false},
+			{"greater", 0x44, true, false, false},
+		} {
+			tc := testCase{
+				name:           fmt.Sprintf("%s %s", op.name, c.name),
+				program:        []uint8{op.opcode, 0x42},
+				setupOverflow:  newBool(true),
+				expectOverflow: true,
+				expectCarry:    c.carry,
+				expectZero:     c.zero,
+				expectNegative: c.negative,
+			}
+			switch op.name {
+			case "CMP":
+				tc.setupA = newUint8(c.register)
+			case "CPX":
+				tc.setupX = newUint8(c.register)
+			case "CPY":
+				tc.setupY = newUint8(c.register)
+			}
+			tests = append(tests, tc)
+		}
+	}
+	tests.run(t)
+}
+
 func TestDEC(t *testing.T) {
 	tests := testCases{
 		// Test DEC with zeropage addressing
@@ -739,7 +1366,7 @@ func TestDEC(t *testing.T) {
 			memory: map[uint16]uint8{
 				0x0010: 0x02, // memory location $10 contains 0x02
 			},
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x0010: 0x01, // memory location $10 should be decremented to 0x01
 			},
 		},
@@ -753,7 +1380,7 @@ func TestDEC(t *testing.T) {
 			memory: map[uint16]uint8{
 				0x0011: 0x03, // memory location $11 ($10 + X) contains 0x03
 			},
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x0011: 0x02, // memory location $11 should be decremented to 0x02
 			},
 		},
@@ -766,10 +1393,54 @@ func TestDEC(t *testing.T) {
 			memory: map[uint16]uint8{
 				0x2001: 0x04, // memory location $2001 contains 0x04
 			},
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x2001: 0x03, // memory location $2001 should be decremented to 0x03
 			},
 		},
+		// Test DEC with Absolute, X addressing
+		{
+			name: "DEC Absolute, X",
+			program: []uint8{
+				0xde, 0x42, 0xaa, // DEC $aa42,X
+			},
+			setupX: newUint8(0x01),
+			memory: map[uint16]uint8{
+				0xaa43: 0x09, // memory location $aa43 ($aa42 + X) contains 0x09
+			},
+			expectDelta: map[uint16]uint8{
+				0xaa43: 0x08, // memory location $aa43 should be decremented to 0x08
+			},
+		},
+		// Test DEC with Absolute, X addressing wrapping to negative
+		{
+			name: "DEC Absolute, X to negative",
+			program: []uint8{
+				0xde, 0x42, 0xaa, // DEC $aa42,X
+			},
+			setupX: newUint8(0x01),
+			memory: map[uint16]uint8{
+				0xaa43: 0x00, // memory location $aa43 ($aa42 + X) contains 0x00
+			},
+			expectDelta: map[uint16]uint8{
+				0xaa43: 0xff, // memory location $aa43 should wrap to 0xff
+			},
+			expectNegative: true,
+		},
+		// Test DEC with Absolute, X addressing to zero
+		{
+			name: "DEC Absolute, X to zero",
+			program: []uint8{
+				0xde, 0x42, 0xaa, // DEC $aa42,X
+			},
+			setupX: newUint8(0x01),
+			memory: map[uint16]uint8{
+				0xaa43: 0x01, // memory location $aa43 ($aa42 + X) contains 0x01
+			},
+			expectDelta: map[uint16]uint8{
+				0xaa43: 0x00, // memory location $aa43 should be decremented to 0x00
+			},
+			expectZero: true,
+		},
 	}
 	tests.run(t)
 }
@@ -872,6 +1543,62 @@ func TestEOR(t *testing.T) {
 	tests.run(t)
 }
 
+func TestLogicalAddressingModes(t *testing.T) {
+	ops := []struct {
+		name   string
+		base   uint8
+		result uint8
+	}{
+		{"AND", 0x20, 0xcc & 0xaa},
+		{"EOR", 0x40, 0xcc ^ 0xaa},
+		{"ORA", 0x00, 0xcc | 0xaa},
+	}
+
+	// opcodes are the op's base plus the mode's offset, the operand is
+	// always $aa with A set to $cc
+	modes := []struct {
+		name   string
+		offset uint8
+		args   []uint8
+		x, y   *uint8
+		memory map[uint16]uint8
+		size   uint16
+		cycles uint64
+	}{
+		{"immediate", 0x09, []uint8{0xaa}, nil, nil, nil, 2, 2},
+		{"zeropage", 0x05, []uint8{0x10}, nil, nil, map[uint16]uint8{0x0010: 0xaa}, 2, 3},
+		{"zeropage,x", 0x15, []uint8{0x10}, newUint8(0x02), nil, map[uint16]uint8{0x0012: 0xaa}, 2, 4},
+		{"zeropage,x wraps", 0x15, []uint8{0xff}, newUint8(0x02), nil, map[uint16]uint8{0x0001: 0xaa}, 2, 4},
+		{"absolute", 0x0d, []uint8{0x34, 0x12}, nil, nil, map[uint16]uint8{0x1234: 0xaa}, 3, 4},
+		{"absolute,x", 0x1d, []uint8{0x34, 0x12}, newUint8(0x02), nil, map[uint16]uint8{0x1236: 0xaa}, 3, 4},
+		{"absolute,x page cross", 0x1d, []uint8{0xff, 0x12}, newUint8(0x02), nil, map[uint16]uint8{0x1301: 0xaa}, 3, 5},
+		{"absolute,y", 0x19, []uint8{0x34, 0x12}, nil, newUint8(0x03), map[uint16]uint8{0x1237: 0xaa}, 3, 4},
+		{"absolute,y page cross", 0x19, []uint8{0xff, 0x12}, nil, newUint8(0x03), map[uint16]uint8{0x1302: 0xaa}, 3, 5},
+		{"(indirect,x)", 0x01, []uint8{0x10}, newUint8(0x02), nil, map[uint16]uint8{0x0012: 0x34, 0x0013: 0x12, 0x1234: 0xaa}, 2, 6},
+		{"(indirect),y", 0x11, []uint8{0x10}, nil, newUint8(0x03), map[uint16]uint8{0x0010: 0x34, 0x0011: 0x12, 0x1237: 0xaa}, 2, 5},
+		{"(indirect),y page cross", 0x11, []uint8{0x10}, nil, newUint8(0x03), map[uint16]uint8{0x0010: 0xff, 0x0011: 0x12, 0x1302: 0xaa}, 2, 6},
+	}
+
+	var tests testCases
+	for _, op := range ops {
+		for _, mode := range modes {
+			tests = append(tests, testCase{
+				name:              fmt.Sprintf("%s %s", op.name, mode.name),
+				program:           append([]uint8{op.base + mode.offset}, mode.args...),
+				memory:            mode.memory,
+				setupA:            newUint8(0xcc),
+				setupX:            mode.x,
+				setupY:            mode.y,
+				expectA:           newUint8(op.result),
+				expectNegative:    op.result&0x80 != 0,
+				expectPC:          newUint16(ProgramStart + mode.size),
+				expectTotalCycles: newUint64(mode.cycles),
+			})
+		}
+	}
+	tests.run(t)
+}
+
 func TestINX(t *testing.T) {
 	tests := testCases{
 		{
@@ -909,30 +1636,113 @@ func TestINY(t *testing.T) {
 func TestINC(t *testing.T) {
 	tests := testCases{
 		{
-			name:         "zeropage",
-			program:      []uint8{0xe6, 0x42},
-			memory:       map[uint16]uint8{0x0042: 0x09},
-			expectMemory: map[uint16]uint8{0x0042: 0x0a},
+			name:        "zeropage",
+			program:     []uint8{0xe6, 0x42},
+			memory:      map[uint16]uint8{0x0042: 0x09},
+			expectDelta: map[uint16]uint8{0x0042: 0x0a},
 		},
 		{
-			name:         "zeropage,x",
-			program:      []uint8{0xf6, 0x42},
-			memory:       map[uint16]uint8{0x0043: 0x09},
-			expectMemory: map[uint16]uint8{0x0043: 0x0a},
-			setupX:       newUint8(0x1),
+			name:        "zeropage,x",
+			program:     []uint8{0xf6, 0x42},
+			memory:      map[uint16]uint8{0x0043: 0x09},
+			expectDelta: map[uint16]uint8{0x0043: 0x0a},
+			setupX:      newUint8(0x1),
 		},
 		{
-			name:         "absolute",
-			program:      []uint8{0xee, 0x42, 0xaa},
-			memory:       map[uint16]uint8{0xaa42: 0x09},
-			expectMemory: map[uint16]uint8{0xaa42: 0x0a},
+			name:        "absolute",
+			program:     []uint8{0xee, 0x42, 0xaa},
+			memory:      map[uint16]uint8{0xaa42: 0x09},
+			expectDelta: map[uint16]uint8{0xaa42: 0x0a},
 		},
 		{
-			name:         "absolute,x",
-			program:      []uint8{0xfe, 0x42, 0xaa},
-			memory:       map[uint16]uint8{0xaa43: 0x09},
-			expectMemory: map[uint16]uint8{0xaa43: 0x0a},
-			setupX:       newUint8(0x1),
+			name:        "absolute,x",
+			program:     []uint8{0xfe, 0x42, 0xaa},
+			memory:      map[uint16]uint8{0xaa43: 0x09},
+			expectDelta: map[uint16]uint8{0xaa43: 0x0a},
+			setupX:      newUint8(0x1),
+		},
+		{
+			name:              "absolute,x page cross",
+			program:           []uint8{0xfe, 0xff, 0xaa},
+			memory:            map[uint16]uint8{0xab00: 0x09},
+			expectDelta:       map[uint16]uint8{0xab00: 0x0a},
+			setupX:            newUint8(0x1),
+			expectTotalCycles: newUint64(7),
+		},
+	}
+	tests.run(t)
+}
+
+func TestINCDECAccumulator(t *testing.T) {
+	tests := testCases{
+		{
+			name:              "INC A",
+			variant:           CMOS65C02,
+			program:           []uint8{0x1a},
+			setupA:            newUint8(0x41),
+			expectA:           newUint8(0x42),
+			expectPC:          newUint16(ProgramStart + 1),
+			expectTotalCycles: newUint64(2),
+		},
+		{
+			name:       "INC A wraps to zero",
+			variant:    CMOS65C02,
+			program:    []uint8{0x1a},
+			setupA:     newUint8(0xff),
+			expectA:    newUint8(0x00),
+			expectZero: true,
+		},
+		{
+			name:           "INC A to negative",
+			variant:        CMOS65C02,
+			program:        []uint8{0x1a},
+			setupA:         newUint8(0x7f),
+			expectA:        newUint8(0x80),
+			expectNegative: true,
+		},
+		{
+			name:              "DEC A",
+			variant:           CMOS65C02,
+			program:           []uint8{0x3a},
+			setupA:            newUint8(0x42),
+			expectA:           newUint8(0x41),
+			expectPC:          newUint16(ProgramStart + 1),
+			expectTotalCycles: newUint64(2),
+		},
+		{
+			name:           "DEC A wraps to negative",
+			variant:        CMOS65C02,
+			program:        []uint8{0x3a},
+			setupA:         newUint8(0x00),
+			expectA:        newUint8(0xff),
+			expectNegative: true,
+		},
+		{
+			name:       "DEC A to zero",
+			variant:    CMOS65C02,
+			program:    []uint8{0x3a},
+			setupA:     newUint8(0x01),
+			expectA:    newUint8(0x00),
+			expectZero: true,
+		},
+		{
+			name:    "INC A leaves memory and X alone",
+			variant: CMOS65C02,
+			program: []uint8{0x1a},
+			setupA:  newUint8(0x10),
+			setupX:  newUint8(0x20),
+			expectA: newUint8(0x11),
+			expectX: newUint8(0x20),
+			expectDelta: map[uint16]uint8{
+				0x0000: 0x00,
+			},
+		},
+		{
+			name:     "1a is a NOP on NMOS",
+			program:  []uint8{0x1a},
+			setupA:   newUint8(0x10),
+			expectA:  newUint8(0x10),
+			expectPC: newUint16(ProgramStart + 1),
 		},
 	}
 	tests.run(t)
@@ -956,6 +1766,61 @@ func TestJMP(t *testing.T) {
 			},
 			expectPC: newUint16(0x2342),
 		},
+		{
+			name:    "indirect page bug takes the high byte from the same page",
+			program: []uint8{0x6c, 0xff, 0x10},
+			memory: map[uint16]uint8{
+				0x10ff: 0x42,
+				0x1100: 0x23,
+				0x1000: 0x33,
+			},
+			expectPC:          newUint16(0x3342),
+			expectTotalCycles: newUint64(5),
+		},
+		{
+			name:    "indirect page bug fixed on CMOS",
+			variant: CMOS65C02,
+			program: []uint8{0x6c, 0xff, 0x10},
+			memory: map[uint16]uint8{
+				0x10ff: 0x42,
+				0x1100: 0x23,
+				0x1000: 0x33,
+			},
+			expectPC:          newUint16(0x2342),
+			expectTotalCycles: newUint64(6),
+		},
+		{
+			name:    "absolute,x indirect jump table",
+			variant: CMOS65C02,
+			program: []uint8{
+				0xa2, 0x02, // LDX #$02
+				0x7c, 0x10, 0xdd, // JMP ($DD10,X)
+			},
+			memory: map[uint16]uint8{
+				// jump table
+				0xdd10: 0x00, 0xdd11: 0x30,
+				0xdd12: 0x10, 0xdd13: 0x30,
+				// handlers
+				0x3000: 0xa9, 0x3001: 0x01, // LDA #$01
+				0x3010: 0xa9, 0x3011: 0x02, // LDA #$02
+			},
+			cycles:            4,
+			expectX:           newUint8(0x02),
+			expectA:           newUint8(0x02),
+			expectPC:          newUint16(0x3012),
+			expectTotalCycles: newUint64(2 + 6 + 2),
+		},
+		{
+			name:    "absolute,x indirect across a page",
+			variant: CMOS65C02,
+			program: []uint8{0x7c, 0xff, 0x10},
+			memory: map[uint16]uint8{
+				0x10ff: 0x42,
+				0x1100: 0x23,
+				0x1000: 0xff,
+			},
+			expectPC: newUint16(0x2342),
+		},
 	}
 	tests.run(t)
 }
@@ -965,9 +1830,9 @@ func TestJSR(t *testing.T) {
 		{
 			name:    "jsr",
 			program: []uint8{0x20, 0x01, 0x04},
-			expectMemory: map[uint16]uint8{
-				stackAddress(StackTop):        0xdd,
-				stackAddress(StackTop - 0x01): 0x02,
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset):        0xdd,
+				stackAddress(StackReset - 0x01): 0x02,
 			},
 		},
 	}
@@ -1061,6 +1926,38 @@ func TestLDA(t *testing.T) {
 			setupY:  newUint8(0x10),
 			expectA: newUint8(0x23),
 		},
+		{
+			name:    "(indirect),y no page cross",
+			program: []uint8{0xb1, 0x70},
+			memory: map[uint16]uint8{
+				0x0070: 0x10,
+				0x0071: 0x30,
+				0x3011: 0x23,
+			},
+			setupY:            newUint8(0x01),
+			expectA:           newUint8(0x23),
+			expectTotalCycles: newUint64(5),
+		},
+		{
+			name:    "(indirect),y page cross",
+			program: []uint8{0xb1, 0x70},
+			memory: map[uint16]uint8{
+				0x0070: 0xff,
+				0x0071: 0x30,
+				0x3100: 0x23,
+			},
+			setupY:            newUint8(0x01),
+			expectA:           newUint8(0x23),
+			expectTotalCycles: newUint64(6),
+		},
+		{
+			name:              "absolute,x page cross",
+			program:           []uint8{0xbd, 0xff, 0x30},
+			memory:            map[uint16]uint8{0x3100: 0x22},
+			setupX:            newUint8(0x01),
+			expectA:           newUint8(0x22),
+			expectTotalCycles: newUint64(5),
+		},
 	}
 	tests.run(t)
 }
@@ -1144,6 +2041,7 @@ func TestLSR(t *testing.T) {
 		{
 			name:    "accumulator",
 			program: []uint8{0x4a},
+			setupA:  newUint8(0xaa),
 			expectA: newUint8(0x55),
 		},
 		{
@@ -1154,34 +2052,34 @@ func TestLSR(t *testing.T) {
 			expectZero: true,
 		},
 		{
-			name:         "zeropage",
-			program:      []uint8{0x46, 0x42},
-			memory:       map[uint16]uint8{0x0042: 0x55},
-			expectMemory: map[uint16]uint8{0x0042: 0x2a},
-			expectCarry:  true,
+			name:        "zeropage",
+			program:     []uint8{0x46, 0x42},
+			memory:      map[uint16]uint8{0x0042: 0x55},
+			expectDelta: map[uint16]uint8{0x0042: 0x2a},
+			expectCarry: true,
 		},
 		{
-			name:         "zeropage,x",
-			program:      []uint8{0x56, 0x42},
-			memory:       map[uint16]uint8{0x0047: 0x55},
-			expectMemory: map[uint16]uint8{0x0047: 0x2a},
-			setupX:       newUint8(0x5),
-			expectCarry:  true,
+			name:        "zeropage,x",
+			program:     []uint8{0x56, 0x42},
+			memory:      map[uint16]uint8{0x0047: 0x55},
+			expectDelta: map[uint16]uint8{0x0047: 0x2a},
+			setupX:      newUint8(0x5),
+			expectCarry: true,
 		},
 		{
-			name:         "absolute",
-			program:      []uint8{0x4e, 0x42},
-			memory:       map[uint16]uint8{0x0042: 0x55},
-			expectMemory: map[uint16]uint8{0x0042: 0x2a},
-			expectCarry:  true,
+			name:        "absolute",
+			program:     []uint8{0x4e, 0x42},
+			memory:      map[uint16]uint8{0x0042: 0x55},
+			expectDelta: map[uint16]uint8{0x0042: 0x2a},
+			expectCarry: true,
 		},
 		{
-			name:         "absolute,x",
-			program:      []uint8{0x5e, 0x42},
-			memory:       map[uint16]uint8{0x0047: 0x55},
-			expectMemory: map[uint16]uint8{0x0047: 0x2a},
-			setupX:       newUint8(0x5),
-			expectCarry:  true,
+			name:        "absolute,x",
+			program:     []uint8{0x5e, 0x42},
+			memory:      map[uint16]uint8{0x0047: 0x55},
+			expectDelta: map[uint16]uint8{0x0047: 0x2a},
+			setupX:      newUint8(0x5),
+			expectCarry: true,
 		},
 	}
 	tests.run(t)
@@ -1194,9 +2092,73 @@ func TestNOP(t *testing.T) {
 			program: []uint8{0xea},
 		},
 	}
+
+	variants := []struct {
+		name    string
+		opcodes []uint8
+		size    uint8
+		cycles  uint64
+		// operand to use, chosen to cross a page for abs,X
+		operand []uint8
+	}{
+		{"implied", []uint8{0xea, 0x1a, 0x3a, 0x5a, 0x7a, 0xda, 0xfa}, 1, 2, nil},
+		{"immediate", []uint8{0x80, 0x82, 0x89, 0xc2, 0xe2}, 2, 2, []uint8{0xff}},
+		{"zeropage", []uint8{0x04, 0x44, 0x64}, 2, 3, []uint8{0x42}},
+		{"zeropage,x", []uint8{0x14, 0x34, 0x54, 0x74, 0xd4, 0xf4}, 2, 4, []uint8{0x42}},
+		{"absolute", []uint8{0x0c}, 3, 4, []uint8{0x42, 0x10}},
+		{"absolute,x", []uint8{0x1c, 0x3c, 0x5c, 0x7c, 0xdc, 0xfc}, 3, 4, []uint8{0x42, 0x10}},
+		{"absolute,x page cross", []uint8{0x1c, 0x3c, 0x5c, 0x7c, 0xdc, 0xfc}, 3, 5, []uint8{0xff, 0x10}},
+	}
+
+	// every variant should leave registers, flags and memory untouched
+	for _, v := range variants {
+		for _, opcode := range v.opcodes {
+			tests = append(tests, testCase{
+				name:                   fmt.Sprintf("%s %02x", v.name, opcode),
+				program:                append([]uint8{opcode}, v.operand...),
+				memory:                 map[uint16]uint8{0x0042: 0x99, 0x1042: 0x99, 0x1100: 0x99},
+				setupA:                 newUint8(0x11),
+				setupX:                 newUint8(0x01),
+				setupY:                 newUint8(0x22),
+				setupSP:                newUint8(0xf0),
+				setupCarry:             newBool(true),
+				setupZero:              newBool(true),
+				setupOverflow:          newBool(true),
+				setupNegative:          newBool(true),
+				setupDecimal:           newBool(true),
+				setupInterruptDisable:  newBool(true),
+				expectCarry:            true,
+				expectZero:             true,
+				expectOverflow:         true,
+				expectNegative:         true,
+				expectDecimal:          newBool(true),
+				expectInterruptDisable: newBool(true),
+				expectA:                newUint8(0x11),
+				expectX:                newUint8(0x01),
+				expectY:                newUint8(0x22),
+				expectSP:               newUint8(0xf0),
+				expectPC:               newUint16(ProgramStart + uint16(v.size)),
+				expectTotalCycles:      newUint64(v.cycles),
+				expectDelta:            map[uint16]uint8{0x0042: 0x99, 0x1042: 0x99, 0x1100: 0x99},
+			})
+		}
+	}
+
 	tests.run(t)
 }
 
+func TestNOPCMOS(t *testing.T) {
+	// the undocumented NOPs are not NOPs on the 65C02
+	cpu := NewMOS6502()
+	cpu.SetVariant(CMOS65C02)
+
+	for _, opcode := range []uint8{0x1a, 0x80, 0x04, 0x14, 0x0c, 0x1c} {
+		if instruction := cpu.instructions[opcode]; instruction != nil && instruction.opc == OPC_NOP {
+			t.Errorf("expected %02x not to be a NOP on the 65C02", opcode)
+		}
+	}
+}
+
 func TestORA(t *testing.T) {
 	tests := testCases{
 		{
@@ -1276,9 +2238,9 @@ func TestPHA(t *testing.T) {
 			program:  []uint8{0x48}, // PHA
 			setupA:   newUint8(0x42),
 			expectA:  newUint8(0x42),
-			expectSP: newUint8(0xfe),
-			expectMemory: map[uint16]uint8{
-				stackAddress(StackTop): 0x42,
+			expectSP: newUint8(StackReset - 1),
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset): 0x42,
 			},
 		},
 		{
@@ -1288,7 +2250,7 @@ func TestPHA(t *testing.T) {
 			setupSP:  newUint8(StackBottom),
 			expectA:  newUint8(0x42),
 			expectSP: newUint8(StackTop),
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				stackAddress(StackBottom): 0x42,
 			},
 		},
@@ -1305,10 +2267,10 @@ func TestPHP(t *testing.T) {
 			},
 			setupCarry: newBool(false),
 			setupZero:  newBool(true),
-			expectMemory: map[uint16]uint8{
-				stackAddress(StackTop): 0x36,
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset): 0x36,
 			},
-			expectSP:    newUint8(StackTop - 0x01),
+			expectSP:    newUint8(StackReset - 0x01),
 			expectZero:  true,
 			expectCarry: false,
 		},
@@ -1319,9 +2281,9 @@ func TestPHP(t *testing.T) {
 			},
 			setupCarry: newBool(true),
 			setupZero:  newBool(true),
-			expectMemory: map[uint16]uint8{
-				stackAddress(StackTop): 0x37},
-			expectSP:    newUint8(StackTop - 0x01),
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset): 0x37},
+			expectSP:    newUint8(StackReset - 0x01),
 			expectZero:  true,
 			expectCarry: true,
 		},
@@ -1331,10 +2293,48 @@ func TestPHP(t *testing.T) {
 				0x08, // PHP
 			},
 			setupNegative:  newBool(true),
-			expectMemory:   map[uint16]uint8{stackAddress(StackTop): 0xb4},
-			expectSP:       newUint8(StackTop - 0x01),
+			expectDelta:    map[uint16]uint8{stackAddress(StackReset): 0xb4},
+			expectSP:       newUint8(StackReset - 0x01),
 			expectNegative: true,
 		},
+		{
+			name: "push processor status with several flags set",
+			program: []uint8{
+				0x08, // PHP
+			},
+			setupCarry:    newBool(true),
+			setupOverflow: newBool(true),
+			setupNegative: newBool(true),
+			// N V - B - I - C
+			expectDelta:    map[uint16]uint8{stackAddress(StackReset): 0xf5},
+			expectSP:       newUint8(StackReset - 0x01),
+			expectCarry:    true,
+			expectOverflow: true,
+			expectNegative: true,
+		},
+		{
+			name: "pull back the pushed flags",
+			program: []uint8{
+				0x08,       // PHP
+				0x18,       // CLC
+				0xb8,       // CLV
+				0xa9, 0x00, // LDA #$00
+				0x28, // PLP
+			},
+			cycles:         6,
+			setupCarry:     newBool(true),
+			setupOverflow:  newBool(true),
+			setupNegative:  newBool(true),
+			expectDelta:    map[uint16]uint8{stackAddress(StackReset): 0xf5},
+			expectSP:       newUint8(StackReset),
+			expectA:        newUint8(0x00),
+			expectCarry:    true,
+			expectOverflow: true,
+			expectNegative: true,
+			// B only exists on the stack
+			expectBreak:            newBool(false),
+			expectInterruptDisable: newBool(true),
+		},
 	}
 	tests.run(t)
 }
@@ -1344,11 +2344,11 @@ func TestPLA(t *testing.T) {
 		{
 			name:     "pull from stack + 1",
 			program:  []uint8{0x68}, // PLA
-			setupSP:  newUint8(StackTop - 0x01),
-			memory:   map[uint16]uint8{stackAddress(StackTop): 0x42},
+			setupSP:  newUint8(StackReset - 0x01),
+			memory:   map[uint16]uint8{stackAddress(StackReset): 0x42},
 			setupA:   newUint8(0x7f),
 			expectA:  newUint8(0x42),
-			expectSP: newUint8(StackTop),
+			expectSP: newUint8(StackReset),
 		},
 		{
 			name:    "pull from stack wrap to bottom",
@@ -1361,6 +2361,27 @@ func TestPLA(t *testing.T) {
 			expectA:  newUint8(0x42),
 			expectSP: newUint8(StackBottom),
 		},
+		{
+			name:       "pull zero sets zero",
+			program:    []uint8{0x68}, // PLA
+			setupSP:    newUint8(StackReset - 0x01),
+			memory:     map[uint16]uint8{stackAddress(StackReset): 0x00},
+			setupA:     newUint8(0x7f),
+			expectA:    newUint8(0x00),
+			expectSP:   newUint8(StackReset),
+			expectZero: true,
+		},
+		{
+			name:           "pull negative sets negative",
+			program:        []uint8{0x68}, // PLA
+			setupSP:        newUint8(StackReset - 0x01),
+			memory:         map[uint16]uint8{stackAddress(StackReset): 0x80},
+			setupA:         newUint8(0x00),
+			setupZero:      newBool(true),
+			expectA:        newUint8(0x80),
+			expectSP:       newUint8(StackReset),
+			expectNegative: true,
+		},
 	}
 	tests.run(t)
 }
@@ -1378,8 +2399,8 @@ func TestPLP(t *testing.T) {
 			expectNegative:         true,
 			expectBreak:            newBool(false),
 			expectReserved:         true,
-			setupSP:                newUint8(StackTop - 0x01),
-			memory:                 map[uint16]uint8{stackAddress(StackTop): 0xff},
+			setupSP:                newUint8(StackReset - 0x01),
+			memory:                 map[uint16]uint8{stackAddress(StackReset): 0xff},
 		},
 		{
 			name:                   "PLP sets no flags",
@@ -1390,8 +2411,8 @@ func TestPLP(t *testing.T) {
 			expectInterruptDisable: newBool(false),
 			expectOverflow:         false,
 			expectNegative:         false,
-			setupSP:                newUint8(StackTop - 0x01),
-			memory:                 map[uint16]uint8{stackAddress(StackTop): 0x00},
+			setupSP:                newUint8(StackReset - 0x01),
+			memory:                 map[uint16]uint8{stackAddress(StackReset): 0x00},
 		},
 		{
 			name:                   "PLP sets some flags",
@@ -1399,8 +2420,58 @@ func TestPLP(t *testing.T) {
 			expectDecimal:          newBool(true),
 			expectInterruptDisable: newBool(true),
 			expectNegative:         true,
-			setupSP:                newUint8(StackTop - 0x01),
-			memory:                 map[uint16]uint8{stackAddress(StackTop): 0x8c},
+			setupSP:                newUint8(StackReset - 0x01),
+			memory:                 map[uint16]uint8{stackAddress(StackReset): 0x8c},
+		},
+	}
+	tests.run(t)
+}
+
+func TestPLAThenPLP(t *testing.T) {
+	tests := testCases{
+		{
+			name: "PLA only changes N and Z",
+			program: []uint8{
+				0xa9, 0x80, // LDA #$80
+				0x48,       // PHA
+				0xa9, 0x01, // LDA #$01
+				0x68, // PLA
+			},
+			cycles:         5,
+			setupCarry:     newBool(true),
+			setupOverflow:  newBool(true),
+			expectA:        newUint8(0x80),
+			expectSP:       newUint8(StackReset),
+			expectCarry:    true,
+			expectOverflow: true,
+			expectNegative: true,
+			expectDelta:    map[uint16]uint8{stackAddress(StackReset): 0x80},
+		},
+		{
+			name: "PLP after PLA restores every flag",
+			program: []uint8{
+				0xa9, 0x80, // LDA #$80
+				0x48,       // PHA
+				0xa9, 0x01, // LDA #$01
+				0x68,       // PLA
+				0xa9, 0xc3, // LDA #$C3
+				0x48, // PHA
+				0x28, // PLP
+			},
+			cycles:        8,
+			setupCarry:    newBool(false),
+			setupOverflow: newBool(false),
+			expectA:       newUint8(0xc3),
+			expectSP:      newUint8(StackReset),
+			// N V - - - - Z C, so I and D end up clear
+			expectCarry:            true,
+			expectZero:             true,
+			expectOverflow:         true,
+			expectNegative:         true,
+			expectDecimal:          newBool(false),
+			expectInterruptDisable: newBool(false),
+			expectBreak:            newBool(false),
+			expectDelta:            map[uint16]uint8{stackAddress(StackReset): 0xc3},
 		},
 	}
 	tests.run(t)
@@ -1442,7 +2513,7 @@ func TestROL(t *testing.T) {
 			memory: map[uint16]uint8{
 				0x0010: 0b01010101,
 			},
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x0010: 0b10101010,
 			},
 			// Flags
@@ -1494,7 +2565,7 @@ func TestROR(t *testing.T) {
 			expectCarry:    false,
 			expectZero:     false,
 			expectNegative: false,
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x0010: 0x02,
 			},
 		},
@@ -1503,30 +2574,100 @@ func TestROR(t *testing.T) {
 	testCases.run(t)
 }
 
+// check every value and carry in against the documented behaviour for both
+// the accumulator and memory forms of the shift and rotate instructions
+func TestShiftRotate(t *testing.T) {
+	shifts := []struct {
+		name        string
+		accumulator uint8
+		zeropage    uint8
+		expect      func(value uint8, carry bool) (uint8, bool)
+	}{
+		{"ASL", 0x0a, 0x06, func(value uint8, carry bool) (uint8, bool) {
+			return value << 1, value&0x80 != 0
+		}},
+		{"LSR", 0x4a, 0x46, func(value uint8, carry bool) (uint8, bool) {
+			return value >> 1, value&0x01 != 0
+		}},
+		{"ROL", 0x2a, 0x26, func(value uint8, carry bool) (uint8, bool) {
+			result := value << 1
+			if carry {
+				result |= 0x01
+			}
+			return result, value&0x80 != 0
+		}},
+		{"ROR", 0x6a, 0x66, func(value uint8, carry bool) (uint8, bool) {
+			result := value >> 1
+			if carry {
+				result |= 0x80
+			}
+			return result, value&0x01 != 0
+		}},
+	}
+
+	for _, shift := range shifts {
+		t.Run(shift.name, func(t *testing.T) {
+			for v := 0; v < 0x100; v++ {
+				for _, carry := range []bool{false, true} {
+					value := uint8(v)
+					result, carryOut := shift.expect(value, carry)
+
+					// accumulator
+					cpu := setup([]uint8{shift.accumulator}, nil)
+					cpu.a = value
+					cpu.p.set(P_Carry, carry)
+					cpu.Step()
+
+					if cpu.a != result {
+						t.Fatalf("A %02x carry %v: expected %02x got %02x", value, carry, result, cpu.a)
+					}
+					expectFlag(t, cpu, P_Carry, carryOut)
+					expectFlag(t, cpu, P_Zero, result == 0)
+					expectFlag(t, cpu, P_Negative, result&0x80 != 0)
+
+					// memory
+					cpu = setup([]uint8{shift.zeropage, 0x42}, map[uint16]uint8{0x42: value})
+					cpu.a = 0x11
+					cpu.p.set(P_Carry, carry)
+					cpu.Step()
+
+					if cpu.memory[0x42] != result {
+						t.Fatalf("$42 %02x carry %v: expected %02x got %02x", value, carry, result, cpu.memory[0x42])
+					}
+					expect8(t, cpu.a, newUint8(0x11))
+					expectFlag(t, cpu, P_Carry, carryOut)
+					expectFlag(t, cpu, P_Zero, result == 0)
+					expectFlag(t, cpu, P_Negative, result&0x80 != 0)
+				}
+			}
+		})
+	}
+}
+
 func TestRTI(t *testing.T) {
 	tests := testCases{
 		{
 			name:    "RTI - Basic",
 			program: []uint8{0x40},
 			memory: map[uint16]uint8{
-				stackAddress(StackTop):     0x12, // Stack: PC High
-				stackAddress(StackTop - 1): 0x34, // Stack: PC Low
-				stackAddress(StackTop - 2): 0x20, // Stack: P
+				stackAddress(StackReset):     0x12, // Stack: PC High
+				stackAddress(StackReset - 1): 0x34, // Stack: PC Low
+				stackAddress(StackReset - 2): 0x20, // Stack: P
 			},
-			setupSP:  newUint8(StackTop - 3),
-			expectSP: newUint8(StackTop),
+			setupSP:  newUint8(StackReset - 3),
+			expectSP: newUint8(StackReset),
 			expectPC: newUint16(0x1234),
 		},
 		{
 			name:    "RTI - Flags",
 			program: []uint8{0x40},
 			memory: map[uint16]uint8{
-				stackAddress(StackTop):     0x12,       // Stack: PC High
-				stackAddress(StackTop - 1): 0x34,       // Stack: PC Low
-				stackAddress(StackTop - 2): 0b11111111, // Stack: P
+				stackAddress(StackReset):     0x12,       // Stack: PC High
+				stackAddress(StackReset - 1): 0x34,       // Stack: PC Low
+				stackAddress(StackReset - 2): 0b11111111, // Stack: P
 			},
-			setupSP:                newUint8(StackTop - 3),
-			expectSP:               newUint8(StackTop),
+			setupSP:                newUint8(StackReset - 3),
+			expectSP:               newUint8(StackReset),
 			expectPC:               newUint16(0x1234),
 			expectCarry:            true,
 			expectZero:             true,
@@ -1571,33 +2712,153 @@ func TestRTS(t *testing.T) {
 			expectA:  newUint8(0x42),
 			expectPC: newUint16(0xdd05),
 		},
+		{
+			name: "RTS - Nested three levels",
+			program: []uint8{
+				// JSR $aa00
+				0x20, 0x00, 0xaa,
+				// INX
+				0xe8,
+			},
+			memory: map[uint16]uint8{
+				// INY, JSR $bb00, RTS
+				0xaa00: 0xc8, 0xaa01: 0x20, 0xaa02: 0x00, 0xaa03: 0xbb, 0xaa04: 0x60,
+				// INY, JSR $cc00, RTS
+				0xbb00: 0xc8, 0xbb01: 0x20, 0xbb02: 0x00, 0xbb03: 0xcc, 0xbb04: 0x60,
+				// INY, RTS
+				0xcc00: 0xc8, 0xcc01: 0x60,
+			},
+			cycles:   11,
+			expectX:  newUint8(0x01),
+			expectY:  newUint8(0x03),
+			expectPC: newUint16(0xdd04),
+			expectSP: newUint8(StackReset),
+			// each level pushed the address of the last byte of its JSR
+			expectDelta: map[uint16]uint8{
+				stackAddress(StackReset):        0xdd,
+				stackAddress(StackReset - 0x01): 0x02,
+				stackAddress(StackReset - 0x02): 0xaa,
+				stackAddress(StackReset - 0x03): 0x03,
+				stackAddress(StackReset - 0x04): 0xbb,
+				stackAddress(StackReset - 0x05): 0x03,
+			},
+		},
 	}
 	tests.run(t)
 }
 
 func TestSBC(t *testing.T) {
 	tests := testCases{
-		// SBC immediate mode, no borrow
+		// SBC immediate mode, carry clear so borrows one more
 		{
-			name:        "SBC immediate mode, no borrow",
+			name:        "SBC immediate mode, with borrow",
 			program:     []uint8{0xE9, 0x01}, // SBC #$01
+			setupCarry:  newBool(false),
 			setupA:      newUint8(0x03),
 			expectA:     newUint8(0x01),
 			expectCarry: true,
 		},
-		// SBC immediate mode, with borrow
+		// SBC immediate mode, carry set so no borrow
 		{
-			name:        "SBC immediate mode, with borrow",
-			program:     []uint8{0xE9, 0x01}, // SBC #$03
+			name:        "SBC immediate mode, no borrow",
+			program:     []uint8{0xE9, 0x01}, // SBC #$01
 			setupCarry:  newBool(true),
 			setupA:      newUint8(0x03),
 			expectA:     newUint8(0x02),
 			expectCarry: true,
 		},
+		{
+			name:          "decimal",
+			program:       []uint8{0xE9, 0x01},
+			setupCarry:    newBool(true),
+			setupDecimal:  newBool(true),
+			setupA:        newUint8(0x10),
+			expectA:       newUint8(0x09),
+			expectCarry:   true,
+			expectDecimal: newBool(true),
+		},
+		{
+			name:           "decimal borrow",
+			program:        []uint8{0xE9, 0x01},
+			setupCarry:     newBool(true),
+			setupDecimal:   newBool(true),
+			setupA:         newUint8(0x00),
+			expectA:        newUint8(0x99),
+			expectNegative: true,
+			expectDecimal:  newBool(true),
+		},
+		{
+			name:              "decimal on CMOS",
+			program:           []uint8{0xE9, 0x01},
+			variant:           CMOS65C02,
+			setupCarry:        newBool(true),
+			setupDecimal:      newBool(true),
+			setupA:            newUint8(0x00),
+			expectA:           newUint8(0x99),
+			expectNegative:    true,
+			expectDecimal:     newBool(true),
+			expectTotalCycles: newUint64(3),
+		},
 	}
 	tests.run(t)
 }
 
+// SBC subtracts M and the inverse of carry, so carry clear going in is a
+// borrow and carry clear coming out means the result borrowed. overflow is
+// set when the signed result does not fit, i.e. positive - negative giving
+// a negative. decimal rows are NMOS where only A differs from binary
+func TestSBCMatrix(t *testing.T) {
+	for _, tc := range []struct {
+		a, m     uint8
+		carry    bool
+		decimal  bool
+		result   uint8
+		carryOut bool
+		overflow bool
+		negative bool
+		zero     bool
+	}{
+		{a: 0x05, m: 0x03, carry: true, result: 0x02, carryOut: true},
+		{a: 0x05, m: 0x03, carry: false, result: 0x01, carryOut: true},
+		{a: 0x03, m: 0x05, carry: true, result: 0xfe, negative: true},
+		{a: 0x00, m: 0x00, carry: true, result: 0x00, carryOut: true, zero: true},
+		{a: 0x00, m: 0x00, carry: false, result: 0xff, negative: true},
+		{a: 0xff, m: 0xff, carry: false, result: 0xff, negative: true},
+		// -128 - 1
+		{a: 0x80, m: 0x01, carry: true, result: 0x7f, carryOut: true, overflow: true},
+		// 127 - -1
+		{a: 0x7f, m: 0xff, carry: true, result: 0x80, overflow: true, negative: true},
+		// 80 - -80
+		{a: 0x50, m: 0xb0, carry: true, result: 0xa0, overflow: true, negative: true},
+		// -48 - 112
+		{a: 0xd0, m: 0x70, carry: true, result: 0x60, carryOut: true, overflow: true},
+
+		{a: 0x46, m: 0x12, carry: true, decimal: true, result: 0x34, carryOut: true},
+		{a: 0x40, m: 0x13, carry: true, decimal: true, result: 0x27, carryOut: true},
+		{a: 0x32, m: 0x02, carry: false, decimal: true, result: 0x29, carryOut: true},
+		{a: 0x12, m: 0x21, carry: true, decimal: true, result: 0x91, negative: true},
+		{a: 0x21, m: 0x34, carry: true, decimal: true, result: 0x87, negative: true},
+		{a: 0x00, m: 0x01, carry: true, decimal: true, result: 0x99, negative: true},
+		{a: 0x00, m: 0x00, carry: false, decimal: true, result: 0x99, negative: true},
+	} {
+		name := fmt.Sprintf("%02x-%02x carry %v decimal %v", tc.a, tc.m, tc.carry, tc.decimal)
+		t.Run(name, func(t *testing.T) {
+			cpu := setup([]uint8{0xe9, tc.m}, nil) // SBC #m
+			cpu.a = tc.a
+			cpu.p.set(P_Carry, tc.carry)
+			cpu.p.set(P_Decimal, tc.decimal)
+
+			cpu.Step()
+
+			expect8(t, cpu.a, &tc.result)
+			expectFlag(t, cpu, P_Carry, tc.carryOut)
+			expectFlag(t, cpu, P_Overflow, tc.overflow)
+			expectFlag(t, cpu, P_Negative, tc.negative)
+			expectFlag(t, cpu, P_Zero, tc.zero)
+		})
+	}
+}
+
 func TestSEC(t *testing.T) {
 	// test cases
 	tests := testCases{
@@ -1642,53 +2903,111 @@ func TestSEI(t *testing.T) {
 func TestSTA(t *testing.T) {
 	tests := testCases{
 		{
-			name:         "zeropage",
-			program:      []uint8{0x85, 0x01},
-			setupA:       newUint8(0x12),
-			expectMemory: map[uint16]uint8{0x0001: 0x12},
+			name:        "zeropage",
+			program:     []uint8{0x85, 0x01},
+			setupA:      newUint8(0x12),
+			expectDelta: map[uint16]uint8{0x0001: 0x12},
+		},
+		{
+			name:        "zeropage,x",
+			program:     []uint8{0x95, 0x01},
+			setupA:      newUint8(0x12),
+			setupX:      newUint8(0x1),
+			expectDelta: map[uint16]uint8{0x0002: 0x12},
 		},
 		{
-			name:         "zeropage,x",
-			program:      []uint8{0x95, 0x01},
-			setupA:       newUint8(0x12),
-			setupX:       newUint8(0x1),
-			expectMemory: map[uint16]uint8{0x0002: 0x12},
+			name:        "absolute",
+			program:     []uint8{0x8d, 0xaa, 0xbb},
+			setupA:      newUint8(0x12),
+			expectDelta: map[uint16]uint8{0xbbaa: 0x12},
 		},
 		{
-			name:         "absolute",
-			program:      []uint8{0x8d, 0xaa, 0xbb},
-			setupA:       newUint8(0x12),
-			expectMemory: map[uint16]uint8{0xbbaa: 0x12},
+			name:        "absolute,x",
+			program:     []uint8{0x9d, 0xaa, 0xbb},
+			setupA:      newUint8(0x12),
+			setupX:      newUint8(0x1),
+			expectDelta: map[uint16]uint8{0xbbab: 0x12},
 		},
 		{
-			name:         "absolute,x",
-			program:      []uint8{0x9d, 0xaa, 0xbb},
-			setupA:       newUint8(0x12),
-			setupX:       newUint8(0x1),
-			expectMemory: map[uint16]uint8{0xbbab: 0x12},
+			name:        "absolute,y",
+			program:     []uint8{0x99, 0xaa, 0xbb},
+			setupA:      newUint8(0x12),
+			setupY:      newUint8(0x1),
+			expectDelta: map[uint16]uint8{0xbbab: 0x12},
 		},
 		{
-			name:         "absolute,y",
-			program:      []uint8{0x99, 0xaa, 0xbb},
-			setupA:       newUint8(0x12),
-			setupY:       newUint8(0x1),
-			expectMemory: map[uint16]uint8{0xbbab: 0x12},
+			name:        "(indirect,x)",
+			program:     []uint8{0x81, 0x70},
+			memory:      map[uint16]uint8{0x0071: 0x0012},
+			setupA:      newUint8(0x12),
+			setupX:      newUint8(0x1),
+			expectDelta: map[uint16]uint8{0x0012: 0x12},
 		},
 		{
-			name:         "(indirect,x)",
-			program:      []uint8{0x81, 0x70},
-			memory:       map[uint16]uint8{0x0071: 0x0012},
-			setupA:       newUint8(0x12),
-			setupX:       newUint8(0x1),
-			expectMemory: map[uint16]uint8{0x0012: 0x12},
+			name:        "(indirect),y",
+			program:     []uint8{0x91, 0x70},
+			memory:      map[uint16]uint8{0x0070: 0x0012},
+			setupA:      newUint8(0x12),
+			setupY:      newUint8(0x1),
+			expectDelta: map[uint16]uint8{0x0013: 0x12},
 		},
 		{
-			name:         "(indirect),y",
-			program:      []uint8{0x91, 0x70},
-			memory:       map[uint16]uint8{0x0070: 0x0012},
-			setupA:       newUint8(0x12),
-			setupY:       newUint8(0x1),
-			expectMemory: map[uint16]uint8{0x0013: 0x12},
+			name:        "(indirect,x) pointer wraps at $ff",
+			program:     []uint8{0x81, 0xfe},
+			memory:      map[uint16]uint8{0x00ff: 0x34, 0x0000: 0x12},
+			setupA:      newUint8(0x42),
+			setupX:      newUint8(0x1),
+			expectDelta: map[uint16]uint8{0x1234: 0x42},
+		},
+		{
+			name:        "(indirect,x) index wraps to $00",
+			program:     []uint8{0x81, 0xf0},
+			memory:      map[uint16]uint8{0x0000: 0x34, 0x0001: 0x12},
+			setupA:      newUint8(0x42),
+			setupX:      newUint8(0x10),
+			expectDelta: map[uint16]uint8{0x1234: 0x42},
+		},
+		{
+			name:        "(indirect),y pointer wraps at $ff",
+			program:     []uint8{0x91, 0xff},
+			memory:      map[uint16]uint8{0x00ff: 0x34, 0x0000: 0x12},
+			setupA:      newUint8(0x42),
+			setupY:      newUint8(0x1),
+			expectDelta: map[uint16]uint8{0x1235: 0x42},
+		},
+		{
+			name:              "(indirect),y no page cross",
+			program:           []uint8{0x91, 0x70},
+			memory:            map[uint16]uint8{0x0070: 0x10, 0x0071: 0x30},
+			setupA:            newUint8(0x42),
+			setupY:            newUint8(0x1),
+			expectDelta:       map[uint16]uint8{0x3011: 0x42},
+			expectTotalCycles: newUint64(6),
+		},
+		{
+			name:              "(indirect),y page cross",
+			program:           []uint8{0x91, 0x70},
+			memory:            map[uint16]uint8{0x0070: 0xff, 0x0071: 0x30},
+			setupA:            newUint8(0x42),
+			setupY:            newUint8(0x1),
+			expectDelta:       map[uint16]uint8{0x3100: 0x42},
+			expectTotalCycles: newUint64(6),
+		},
+		{
+			name:              "absolute,x page cross",
+			program:           []uint8{0x9d, 0xff, 0x30},
+			setupA:            newUint8(0x42),
+			setupX:            newUint8(0x1),
+			expectDelta:       map[uint16]uint8{0x3100: 0x42},
+			expectTotalCycles: newUint64(5),
+		},
+		{
+			name:              "absolute,y page cross",
+			program:           []uint8{0x99, 0xff, 0x30},
+			setupA:            newUint8(0x42),
+			setupY:            newUint8(0x1),
+			expectDelta:       map[uint16]uint8{0x3100: 0x42},
+			expectTotalCycles: newUint64(5),
 		},
 	}
 	tests.run(t)
@@ -1703,7 +3022,7 @@ func TestSTX(t *testing.T) {
 				0x86, 0x10,
 			},
 			setupX: newUint8(0x42),
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x0010: 0x42,
 			},
 			expectX: newUint8(0x42),
@@ -1715,7 +3034,7 @@ func TestSTX(t *testing.T) {
 			},
 			setupX: newUint8(0x42),
 			setupY: newUint8(0x04),
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x0014: 0x42,
 			},
 			expectX: newUint8(0x42),
@@ -1726,7 +3045,7 @@ func TestSTX(t *testing.T) {
 				0x8e, 0x34, 0x12, // STX $1234
 			},
 			setupX: newUint8(0x42),
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				ProgramStart + 0: 0x8e, // STX $1234
 				ProgramStart + 1: 0x34, // address low byte
 				ProgramStart + 2: 0x12, // address high byte
@@ -1748,7 +3067,7 @@ func TestSTY(t *testing.T) {
 				0x84, 0x10, // STY $10
 			},
 			setupY: newUint8(0xab),
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x0010: 0xab,
 			},
 		},
@@ -1759,7 +3078,7 @@ func TestSTY(t *testing.T) {
 			},
 			setupY: newUint8(0xcd),
 			setupX: newUint8(0x03),
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x0013: 0xcd,
 			},
 		},
@@ -1769,7 +3088,7 @@ func TestSTY(t *testing.T) {
 				0x8c, 0x34, 0x12, // STY $1234
 			},
 			setupY: newUint8(0xef),
-			expectMemory: map[uint16]uint8{
+			expectDelta: map[uint16]uint8{
 				0x1234: 0xef,
 			},
 		},
@@ -1778,6 +3097,48 @@ func TestSTY(t *testing.T) {
 	testCases.run(t)
 }
 
+func TestStoreFlags(t *testing.T) {
+	// stores never touch the flags whatever the value stored
+	var tests testCases
+	for _, store := range []struct {
+		name    string
+		program []uint8
+	}{
+		{"STA zeropage", []uint8{0x85, 0x10}},
+		{"STA absolute,x", []uint8{0x9d, 0x00, 0x04}},
+		{"STA (indirect),y", []uint8{0x91, 0x20}},
+		{"STX zeropage", []uint8{0x86, 0x10}},
+		{"STX zeropage,y", []uint8{0x96, 0x10}},
+		{"STY zeropage", []uint8{0x84, 0x10}},
+		{"STY absolute", []uint8{0x8c, 0x00, 0x04}},
+	} {
+		for _, value := range []uint8{0x00, 0x80} {
+			for _, set := range []bool{true, false} {
+				tests = append(tests, testCase{
+					name:                   fmt.Sprintf("%s %02x flags set %t", store.name, value, set),
+					program:                store.program,
+					setupA:                 newUint8(value),
+					setupX:                 newUint8(value),
+					setupY:                 newUint8(value),
+					setupCarry:             newBool(set),
+					setupZero:              newBool(set),
+					setupDecimal:           newBool(set),
+					setupInterruptDisable:  newBool(set),
+					setupOverflow:          newBool(set),
+					setupNegative:          newBool(set),
+					expectCarry:            set,
+					expectZero:             set,
+					expectDecimal:          newBool(set),
+					expectInterruptDisable: newBool(set),
+					expectOverflow:         set,
+					expectNegative:         set,
+				})
+			}
+		}
+	}
+	tests.run(t)
+}
+
 func TestTAX(t *testing.T) {
 	tests := testCases{
 		{
@@ -0,0 +1,47 @@
+package cpu
+
+// reads of memory that has never been written return a sentinel value
+type poison struct {
+	value  uint8
+	onRead func(address uint16)
+	// one bit per address set once it has been written or initialized
+	written [0x10000 / 64]uint64
+}
+
+// Poison makes reads of addresses that have never been written through the
+// cpu return value instead of what is in memory, calling onRead if it is
+// set. this catches programs relying on RAM starting as zero. anything
+// loaded before, such as the program, vectors and device registers, has to
+// be marked with Initialize
+func (cpu *MOS6502) Poison(value uint8, onRead func(address uint16)) {
+	cpu.poison = &poison{
+		value:  value,
+		onRead: onRead,
+	}
+}
+
+// Initialize marks start to end (inclusive) as written so reads of it are
+// not poisoned
+func (cpu *MOS6502) Initialize(start, end uint16) {
+	if cpu.poison == nil {
+		return
+	}
+	for address := uint32(start); address <= uint32(end); address++ {
+		cpu.poison.mark(uint16(address))
+	}
+}
+
+func (p *poison) mark(address uint16) {
+	p.written[address/64] |= 1 << (address % 64)
+}
+
+// the poison value and true if address has never been written
+func (p *poison) read(address uint16) (uint8, bool) {
+	if p.written[address/64]&(1<<(address%64)) != 0 {
+		return 0, false
+	}
+	if p.onRead != nil {
+		p.onRead(address)
+	}
+	return p.value, true
+}
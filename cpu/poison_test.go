@@ -0,0 +1,41 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestPoison(t *testing.T) {
+	program := []uint8{
+		0xa5, 0x10, // LDA $10
+		0xa9, 0x05, // LDA #$05
+		0x85, 0x20, // STA $20
+		0xa6, 0x20, // LDX $20
+		0xa4, 0x30, // LDY $30
+	}
+	cpu := setup(program, map[uint16]uint8{0x30: 0x01})
+
+	var poisoned []uint16
+	cpu.Poison(0xaa, func(address uint16) {
+		poisoned = append(poisoned, address)
+	})
+	cpu.Initialize(ProgramStart, ProgramStart+uint16(len(program))-1)
+	// as if loaded by the host
+	cpu.Initialize(0x30, 0x30)
+
+	// never written so reads back the poison value
+	cpu.Step()
+	expect8(t, cpu.a, newUint8(0xaa))
+
+	// written through the cpu first so is real
+	cpu.Step()
+	cpu.Step()
+	cpu.Step()
+	expect8(t, cpu.x, newUint8(0x05))
+
+	cpu.Step()
+	expect8(t, cpu.y, newUint8(0x01))
+
+	if len(poisoned) != 1 || poisoned[0] != 0x0010 {
+		t.Errorf("expected one poisoned read of $0010 got %04x", poisoned)
+	}
+}
@@ -0,0 +1,155 @@
+package cpu
+
+import (
+	"fmt"
+)
+
+// Registers is a copy of the register file for debuggers and monitors
+type Registers struct {
+	A  uint8
+	X  uint8
+	Y  uint8
+	SP uint8
+	PC uint16
+	P  uint8
+}
+
+// Registers returns a snapshot of the current register values
+func (cpu *MOS6502) Registers() Registers {
+	return Registers{
+		A:  cpu.a,
+		X:  cpu.x,
+		Y:  cpu.y,
+		SP: cpu.sp,
+		PC: cpu.pc,
+		P:  uint8(cpu.p),
+	}
+}
+
+// SetRegisters loads the whole register file at once, i.e. to restore a
+// snapshot taken with Registers. p is used as is, unlike SetStatus
+func (cpu *MOS6502) SetRegisters(a, x, y, sp uint8, pc uint16, p uint8) {
+	cpu.a = a
+	cpu.x = x
+	cpu.y = y
+	cpu.sp = sp
+	cpu.pc = pc
+	cpu.p = flags(p)
+}
+
+func (r Registers) String() string {
+	p := flags(r.P)
+	return fmt.Sprintf("PC:%04x A:%02x X:%02x Y:%02x SP:%02x P:%s", r.PC, r.A, r.X, r.Y, r.SP, p.String())
+}
+
+// Flags is a decoded view of the status register
+type Flags struct {
+	Carry            bool
+	Zero             bool
+	InterruptDisable bool
+	Decimal          bool
+	Break            bool
+	Overflow         bool
+	Negative         bool
+}
+
+// Flags returns the status register decoded in to one bool per flag
+func (cpu *MOS6502) Flags() Flags {
+	return cpu.p.decode()
+}
+
+// StatusByte returns the status register as PHP would push it, with the
+// break flag and bit 5 set
+func (cpu *MOS6502) StatusByte() uint8 {
+	p := cpu.p
+	p.set(P_Break, true)
+	p.set(P_Reserved, true)
+	return uint8(p)
+}
+
+// SetStatus loads the status register from a byte as PLP does, the break
+// flag only exists on the stack so is ignored and bit 5 is always set
+func (cpu *MOS6502) SetStatus(p uint8) {
+	status := flags(p)
+	status.set(P_Break, false)
+	status.set(P_Reserved, true)
+	cpu.p = status
+}
+
+func (a *flags) decode() Flags {
+	return Flags{
+		Carry:            a.isSet(P_Carry),
+		Zero:             a.isSet(P_Zero),
+		InterruptDisable: a.isSet(P_InterruptDisable),
+		Decimal:          a.isSet(P_Decimal),
+		Break:            a.isSet(P_Break),
+		Overflow:         a.isSet(P_Overflow),
+		Negative:         a.isSet(P_Negative),
+	}
+}
+
+// StateLine formats the registers and cycle count on one line for diffing
+// against reference logs, i.e.
+//
+//	A:42 X:00 Y:00 P:nv-BdIzc SP:FD PC:DD00 CYC:12
+//
+// flags are uppercase when set and lowercase when clear
+func (cpu *MOS6502) StateLine() string {
+	return fmt.Sprintf(
+		"A:%02X X:%02X Y:%02X P:%s SP:%02X PC:%04X CYC:%d",
+		cpu.a, cpu.x, cpu.y, cpu.p.letters(), cpu.sp, cpu.pc, cpu.TotalCycles,
+	)
+}
+
+func (a *flags) letters() string {
+	b := []byte("nv-bdizc")
+	for i, f := range []flag{P_Negative, P_Overflow, P_Reserved, P_Break, P_Decimal, P_InterruptDisable, P_Zero, P_Carry} {
+		if f != P_Reserved && a.isSet(f) {
+			b[i] -= 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// Equal compares the registers, including the status register, and memory
+// of two cpus for differential testing. when they differ the first
+// divergence is described, registers before memory
+func (cpu *MOS6502) Equal(other *MOS6502) (bool, string) {
+	a, b := cpu.Registers(), other.Registers()
+
+	for _, r := range []struct {
+		name string
+		a, b uint8
+	}{
+		{"A", a.A, b.A},
+		{"X", a.X, b.X},
+		{"Y", a.Y, b.Y},
+		{"SP", a.SP, b.SP},
+	} {
+		if r.a != r.b {
+			return false, fmt.Sprintf("%s %02x != %02x", r.name, r.a, r.b)
+		}
+	}
+
+	if a.PC != b.PC {
+		return false, fmt.Sprintf("PC %04x != %04x", a.PC, b.PC)
+	}
+	if a.P != b.P {
+		pa, pb := flags(a.P), flags(b.P)
+		return false, fmt.Sprintf("P %s != %s", pa.letters(), pb.letters())
+	}
+
+	switch {
+	case cpu.memory == other.memory:
+	case cpu.memory == nil || other.memory == nil:
+		return false, "only one has memory"
+	default:
+		for address := range cpu.memory {
+			if cpu.memory[address] != other.memory[address] {
+				return false, fmt.Sprintf("memory %04x %02x != %02x", address, cpu.memory[address], other.memory[address])
+			}
+		}
+	}
+
+	return true, ""
+}
@@ -0,0 +1,175 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestRegisters(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa9, 0x80, // LDA #$80
+		0xa2, 0x01, // LDX #$01
+		0xa0, 0x02, // LDY #$02
+		0x48, // PHA
+	}, nil)
+
+	for i := 0; i < 4; i++ {
+		cpu.Step()
+	}
+
+	r := cpu.Registers()
+
+	expected := Registers{
+		A:  0x80,
+		X:  0x01,
+		Y:  0x02,
+		SP: 0xfc,
+		PC: ProgramStart + 7,
+		P:  0b00110100,
+	}
+
+	if r != expected {
+		t.Errorf("expected %s got %s", expected, r)
+	}
+
+	if s := r.String(); s != "PC:dd07 A:80 X:01 Y:02 SP:fc P:---B-I--" {
+		t.Errorf("unexpected string %q", s)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	cpu := NewMOS6502()
+	cpu.p = 0b11001001
+
+	expected := Flags{
+		Carry:    true,
+		Decimal:  true,
+		Overflow: true,
+		Negative: true,
+	}
+	if f := cpu.Flags(); f != expected {
+		t.Errorf("expected %+v got %+v", expected, f)
+	}
+
+	cpu.p = 0b00110110
+
+	expected = Flags{
+		Zero:             true,
+		InterruptDisable: true,
+		Break:            true,
+	}
+	if f := cpu.Flags(); f != expected {
+		t.Errorf("expected %+v got %+v", expected, f)
+	}
+}
+
+func TestStateLine(t *testing.T) {
+	cpu := setup([]uint8{
+		0xf8,       // SED
+		0xa9, 0x42, // LDA #$42
+		0x48, // PHA
+	}, nil)
+
+	expected := "A:00 X:00 Y:00 P:nv-BdIzc SP:FD PC:DD00 CYC:0"
+	if s := cpu.StateLine(); s != expected {
+		t.Errorf("expected %q got %q", expected, s)
+	}
+
+	for i := 0; i < 3; i++ {
+		cpu.Step()
+	}
+
+	expected = "A:42 X:00 Y:00 P:nv-BDIzc SP:FC PC:DD04 CYC:7"
+	if s := cpu.StateLine(); s != expected {
+		t.Errorf("expected %q got %q", expected, s)
+	}
+}
+
+func TestStatusByte(t *testing.T) {
+	for _, p := range []uint8{0x00, 0x24, 0xc3, 0xff} {
+		cpu := setup([]uint8{0x08}, nil) // PHP
+		cpu.p = flags(p)
+
+		status := cpu.StatusByte()
+		cpu.Step()
+
+		expect8(t, status, &cpu.memory[stackAddress(StackReset)])
+		expect8(t, status, newUint8(p|0x30))
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	cpu := NewMOS6502()
+	cpu.SetStatus(0b11000011)
+
+	expected := Flags{
+		Carry:    true,
+		Zero:     true,
+		Overflow: true,
+		Negative: true,
+	}
+	if f := cpu.Flags(); f != expected {
+		t.Errorf("expected %+v got %+v", expected, f)
+	}
+	expectFlag(t, cpu, P_Reserved, true)
+
+	// break is dropped like PLP and comes back when pushed
+	cpu.SetStatus(0xff)
+	expectFlag(t, cpu, P_Break, false)
+	expect8(t, cpu.StatusByte(), newUint8(0xff))
+}
+
+func TestEqual(t *testing.T) {
+	program := []uint8{
+		0xa9, 0x42, // LDA #$42
+		0x85, 0x10, // STA $10
+	}
+	a := setup(program, nil)
+	b := setup(program, nil)
+
+	for i := 0; i < 2; i++ {
+		a.Step()
+		b.Step()
+	}
+	if equal, diff := a.Equal(b); !equal {
+		t.Fatalf("expected equal got %s", diff)
+	}
+
+	// memory alone
+	b.memory[0x1234] = 0x01
+	if equal, diff := a.Equal(b); equal || diff != "memory 1234 00 != 01" {
+		t.Errorf("expected the memory to differ got %v %q", equal, diff)
+	}
+
+	// registers are reported first
+	b.x = 0x05
+	if equal, diff := a.Equal(b); equal || diff != "X 00 != 05" {
+		t.Errorf("expected X to differ got %v %q", equal, diff)
+	}
+
+	b.x = 0x00
+	b.p.set(P_Carry, true)
+	if equal, diff := a.Equal(b); equal || diff != "P nv-BdIzc != nv-BdIzC" {
+		t.Errorf("expected P to differ got %v %q", equal, diff)
+	}
+}
+
+func TestSetRegisters(t *testing.T) {
+	cpu := setup([]uint8{0xe8}, nil) // INX
+
+	cpu.SetRegisters(0x01, 0x02, 0x03, 0xf0, 0x1234, 0xe3)
+
+	expected := Registers{A: 0x01, X: 0x02, Y: 0x03, SP: 0xf0, PC: 0x1234, P: 0xe3}
+	if r := cpu.Registers(); r != expected {
+		t.Errorf("expected %s got %s", expected, r)
+	}
+	expect16(t, cpu.PC(), newUint16(0x1234))
+
+	// restoring a snapshot puts everything back
+	cpu.SetPC(ProgramStart)
+	before := cpu.Registers()
+	cpu.Step()
+	cpu.SetRegisters(before.A, before.X, before.Y, before.SP, before.PC, before.P)
+	if r := cpu.Registers(); r != before {
+		t.Errorf("expected %s got %s", before, r)
+	}
+}
@@ -0,0 +1,221 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EventType describes an external input to the cpu
+type EventType uint8
+
+const (
+	EventIRQ EventType = iota
+	EventNMI
+	EventRead
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventIRQ:
+		return "IRQ"
+	case EventNMI:
+		return "NMI"
+	case EventRead:
+		return "READ"
+	}
+	return fmt.Sprintf("EVENT(%d)", uint8(e))
+}
+
+// Event is an external input and the cycle it was seen on. Address and
+// Value are only used by reads
+type Event struct {
+	Cycle   uint64
+	Type    EventType
+	Address uint16
+	Value   uint8
+}
+
+// Recorder logs the external inputs to a cpu, interrupts raised through
+// it and reads from devices wrapped by it, so the session can be replayed
+// deterministically with a Player
+type Recorder struct {
+	cpu    *MOS6502
+	Events []Event
+}
+
+func NewRecorder(cpu *MOS6502) *Recorder {
+	return &Recorder{
+		cpu: cpu,
+	}
+}
+
+func (r *Recorder) record(t EventType, address uint16, value uint8) {
+	r.Events = append(r.Events, Event{
+		Cycle:   r.cpu.TotalCycles,
+		Type:    t,
+		Address: address,
+		Value:   value,
+	})
+}
+
+// IRQ raises and records an interrupt request
+func (r *Recorder) IRQ() {
+	r.record(EventIRQ, 0, 0)
+	r.cpu.IRQ()
+}
+
+// NMI raises and records a non-maskable interrupt
+func (r *Recorder) NMI() {
+	r.record(EventNMI, 0, 0)
+	r.cpu.NMI()
+}
+
+// Device wraps a device so every value read from it is recorded
+func (r *Recorder) Device(device Bus) Bus {
+	return &recordedDevice{
+		recorder: r,
+		device:   device,
+	}
+}
+
+// WriteTo writes the events one per line as "cycle type address value"
+func (r *Recorder) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, e := range r.Events {
+		n, err := fmt.Fprintf(w, "%d %s %04x %02x\n", e.Cycle, e.Type, e.Address, e.Value)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ReadEvents parses events written by Recorder.WriteTo
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var e Event
+		var t string
+		_, err := fmt.Sscanf(text, "%d %s %x %x", &e.Cycle, &t, &e.Address, &e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		switch t {
+		case "IRQ":
+			e.Type = EventIRQ
+		case "NMI":
+			e.Type = EventNMI
+		case "READ":
+			e.Type = EventRead
+		default:
+			return nil, fmt.Errorf("line %d: unknown event %q", line, t)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+type recordedDevice struct {
+	recorder *Recorder
+	device   Bus
+}
+
+func (d *recordedDevice) Read(address uint16) uint8 {
+	value := d.device.Read(address)
+	d.recorder.record(EventRead, address, value)
+	return value
+}
+
+func (d *recordedDevice) Write(address uint16, value uint8) {
+	d.device.Write(address, value)
+}
+
+// Player feeds recorded events back in to a cpu. Interrupts are raised
+// from the cpu's OnCycle hook once the recorded cycle is reached and reads
+// from wrapped devices return the recorded values in order
+type Player struct {
+	cpu        *MOS6502
+	interrupts []Event
+	reads      []Event
+}
+
+// NewPlayer attaches a player to the cpu, taking over its OnCycle hook
+func NewPlayer(cpu *MOS6502, events []Event) *Player {
+	p := &Player{
+		cpu: cpu,
+	}
+
+	for _, e := range events {
+		if e.Type == EventRead {
+			p.reads = append(p.reads, e)
+		} else {
+			p.interrupts = append(p.interrupts, e)
+		}
+	}
+
+	cpu.OnCycle = p.cycle
+
+	return p
+}
+
+// raise any interrupts that are due
+func (p *Player) cycle(cpu *MOS6502) {
+	for len(p.interrupts) > 0 && p.interrupts[0].Cycle <= cpu.TotalCycles {
+		switch p.interrupts[0].Type {
+		case EventIRQ:
+			cpu.IRQ()
+		case EventNMI:
+			cpu.NMI()
+		}
+		p.interrupts = p.interrupts[1:]
+	}
+}
+
+// Done reports if every recorded event has been played back
+func (p *Player) Done() bool {
+	return len(p.interrupts) == 0 && len(p.reads) == 0
+}
+
+// Device wraps a device so reads return the recorded values instead,
+// falling back to the device once the recording runs out
+func (p *Player) Device(device Bus) Bus {
+	return &playedDevice{
+		player: p,
+		device: device,
+	}
+}
+
+type playedDevice struct {
+	player *Player
+	device Bus
+}
+
+func (d *playedDevice) Read(address uint16) uint8 {
+	reads := d.player.reads
+	if len(reads) > 0 && reads[0].Address == address {
+		d.player.reads = reads[1:]
+		return reads[0].Value
+	}
+	return d.device.Read(address)
+}
+
+func (d *playedDevice) Write(address uint16, value uint8) {
+	d.device.Write(address, value)
+}
@@ -0,0 +1,130 @@
+package cpu
+
+import (
+	"bytes"
+	"testing"
+)
+
+// reads keys into $0200,X and counts interrupts in Y
+var replayProgram = []uint8{
+	0x58,             // CLI
+	0xe8,             // loop: INX
+	0xad, 0x11, 0xd0, // LDA $D011
+	0x10, 0xfa, // BPL loop
+	0xad, 0x10, 0xd0, // LDA $D010
+	0x9d, 0x00, 0x02, // STA $0200,X
+	0x4c, 0x01, 0xdd, // JMP loop
+}
+
+func setupReplay() *MOS6502 {
+	return setupInterrupts(replayProgram)
+}
+
+func expectSameState(t *testing.T, a, b *MOS6502) {
+	t.Helper()
+
+	expect8(t, b.a, &a.a)
+	expect8(t, b.x, &a.x)
+	expect8(t, b.y, &a.y)
+	expect8(t, b.sp, &a.sp)
+	expect16(t, b.pc, &a.pc)
+	expect64(t, b.TotalCycles, &a.TotalCycles)
+
+	if a.p != b.p {
+		t.Errorf("expected p %s got %s", a.p.String(), b.p.String())
+	}
+	if *a.memory != *b.memory {
+		t.Errorf("expected memory to match")
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	// record a session with key presses and an interrupt
+	recorded := setupReplay()
+	recorder := NewRecorder(recorded)
+
+	keys := make(chan uint8, 1)
+	recorded.SetBus(recorder.Device(NewKeyboard(recorded.memory, keys)))
+
+	for i := 0; i < 200; i++ {
+		switch i {
+		case 20:
+			keys <- 'A'
+		case 50:
+			recorder.IRQ()
+		case 80:
+			keys <- 'B'
+		case 120:
+			recorder.IRQ()
+		}
+		recorded.Step()
+	}
+
+	if recorded.y != 0x02 {
+		t.Fatalf("expected 2 interrupts to be taken got %d", recorded.y)
+	}
+	if !bytes.Contains(recorded.memory[0x0200:0x0300], []byte("A")) || !bytes.Contains(recorded.memory[0x0200:0x0300], []byte("B")) {
+		t.Fatalf("expected both keys to be stored")
+	}
+
+	// save and load the log
+	buf := &bytes.Buffer{}
+	if _, err := recorder.WriteTo(buf); err != nil {
+		t.Fatalf("unexpected error writing events: %s", err)
+	}
+	events, err := ReadEvents(buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading events: %s", err)
+	}
+	if len(events) != len(recorder.Events) {
+		t.Fatalf("expected %d events got %d", len(recorder.Events), len(events))
+	}
+
+	// replay with a keyboard that never receives any keys
+	replayed := setupReplay()
+	player := NewPlayer(replayed, events)
+	replayed.SetBus(player.Device(NewKeyboard(replayed.memory, make(chan uint8))))
+
+	for i := 0; i < 200; i++ {
+		replayed.Step()
+	}
+
+	if !player.Done() {
+		t.Errorf("expected all events to be played back")
+	}
+
+	expectSameState(t, recorded, replayed)
+}
+
+func TestReplayCycles(t *testing.T) {
+	// the same recording replayed by single cycles rather than steps
+	recorded := setupReplay()
+	recorder := NewRecorder(recorded)
+
+	for i := 0; i < 500; i++ {
+		if i == 101 {
+			recorder.IRQ()
+		}
+		recorded.Cycle()
+	}
+
+	replayed := setupReplay()
+	NewPlayer(replayed, recorder.Events)
+
+	for i := 0; i < 500; i++ {
+		replayed.Cycle()
+	}
+
+	if replayed.y != 0x01 {
+		t.Fatalf("expected the interrupt to be taken")
+	}
+
+	expectSameState(t, recorded, replayed)
+}
+
+func TestReadEventsInvalid(t *testing.T) {
+	_, err := ReadEvents(bytes.NewBufferString("10 FOO 0000 00\n"))
+	if err == nil {
+		t.Errorf("expected an error for an unknown event")
+	}
+}
@@ -0,0 +1,115 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TraceFormat selects how Debug logs each instruction
+type TraceFormat uint8
+
+const (
+	// address, opcode, disassembly, flags and registers
+	DefaultFormat TraceFormat = iota
+	// the layout of nestest.log, without its PPU column or memory
+	// annotations, so traces can be diffed against golden logs i.e.
+	// C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD CYC:7
+	NestestFormat
+)
+
+// TraceEntry is an instruction about to execute along with the state of
+// the cpu before it runs
+type TraceEntry struct {
+	Registers
+	Opcode      uint8
+	Disassembly string
+	Cycles      uint64
+}
+
+// AddTraceSink calls fn with every instruction before it executes, as
+// many sinks as needed can be added, i.e. one writing a log file and
+// another keeping the last few entries for a post-mortem. unlike Debug
+// they are called regardless of it being set
+func (cpu *MOS6502) AddTraceSink(fn func(TraceEntry)) {
+	cpu.traceSinks = append(cpu.traceSinks, fn)
+}
+
+// pass the instruction at the pc to every sink
+func (cpu *MOS6502) trace(opcode uint8) {
+	entry := TraceEntry{
+		Registers:   cpu.Registers(),
+		Opcode:      opcode,
+		Disassembly: cpu.traceDisassembly(),
+		Cycles:      cpu.TotalCycles,
+	}
+	for _, sink := range cpu.traceSinks {
+		sink(entry)
+	}
+}
+
+// format the instruction about to execute at the pc
+func (cpu *MOS6502) traceLine(opcode uint8, instruction *instruction) string {
+	disassembly := cpu.traceDisassembly()
+
+	if cpu.TraceFormat == NestestFormat {
+		// B only exists when pushed and bit 5 always reads as set
+		p := cpu.p
+		p.set(P_Break, false)
+		p.set(P_Reserved, true)
+
+		raw := make([]string, instruction.size)
+		for i := range raw {
+			raw[i] = fmt.Sprintf("%02X", cpu.memory.Read(cpu.pc+uint16(i)))
+		}
+		return fmt.Sprintf(
+			"%04X  %-10s%-32sA:%02X X:%02X Y:%02X P:%02X SP:%02X CYC:%d",
+			cpu.pc,
+			strings.Join(raw, " "),
+			disassembly,
+			cpu.a,
+			cpu.x,
+			cpu.y,
+			uint8(p),
+			cpu.sp,
+			cpu.TotalCycles,
+		)
+	}
+
+	return fmt.Sprintf(
+		"%04x : %02x\t%-30s\t%s\tA:%02x X:%02x Y:%02x\tSP:%04x",
+		cpu.pc,
+		opcode,
+		disassembly,
+		cpu.p.String(),
+		cpu.a,
+		cpu.x,
+		cpu.y,
+		cpu.sp,
+	)
+}
+
+// disassembly of the instruction at the pc, from the cache if enabled
+func (cpu *MOS6502) traceDisassembly() string {
+	if !cpu.CacheDisassembly {
+		return cpu.Disassemble(cpu.pc).Disassembly
+	}
+
+	if disassembly, ok := cpu.disasmCache[cpu.pc]; ok {
+		return disassembly
+	}
+	if cpu.disasmCache == nil {
+		cpu.disasmCache = make(map[uint16]string)
+	}
+
+	disassembly := cpu.Disassemble(cpu.pc).Disassembly
+	cpu.disasmCache[cpu.pc] = disassembly
+	return disassembly
+}
+
+// drop the cached disassembly of any instruction with a byte at address,
+// instructions are at most 3 bytes so it can start up to 2 before
+func (cpu *MOS6502) invalidateDisassembly(address uint16) {
+	delete(cpu.disasmCache, address)
+	delete(cpu.disasmCache, address-1)
+	delete(cpu.disasmCache, address-2)
+}
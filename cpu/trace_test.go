@@ -0,0 +1,214 @@
+package cpu
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNestestFormat(t *testing.T) {
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	// the first lines of nestest.log straight from reset
+	cpu := setupAt(0xc000, []uint8{0x4c, 0xf5, 0xc5}, map[uint16]uint8{
+		0xc5f5: 0xa2, 0xc5f6: 0x00, // LDX #$00
+		0xc5f7: 0x86, 0xc5f8: 0x00, // STX $00
+	})
+	// nestest counts the 7 cycles of the reset sequence
+	cpu.TotalCycles = 7
+	cpu.Debug = true
+	cpu.TraceFormat = NestestFormat
+
+	for i := 0; i < 3; i++ {
+		cpu.Step()
+	}
+
+	expected := []string{
+		"C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD CYC:7",
+		"C5F5  A2 00     LDX #$00                        A:00 X:00 Y:00 P:24 SP:FD CYC:10",
+		"C5F7  86 00     STX $00                         A:00 X:00 Y:00 P:26 SP:FD CYC:12",
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines got %d: %q", len(expected), len(lines), out.String())
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("expected\n%q\ngot\n%q", expected[i], lines[i])
+		}
+	}
+
+	// pulling the status back does not change how it is shown
+	out.Reset()
+	cpu, err := NewFromAssembly(`
+		PHP
+		PLP
+		NOP
+	`, 0xc000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu.Debug = true
+	cpu.TraceFormat = NestestFormat
+	for i := 0; i < 3; i++ {
+		cpu.Step()
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !strings.Contains(line, " P:24 ") {
+			t.Errorf("expected P:24 got %q", line)
+		}
+	}
+}
+
+func TestCacheDisassemblySelfModifying(t *testing.T) {
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	program, err := Assemble(`
+		loop:	LDA #$00
+			INC $DD01
+			JMP loop
+	`, ProgramStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := setup(program.Bytes, nil)
+	cpu.Debug = true
+	cpu.CacheDisassembly = true
+
+	for i := 0; i < 9; i++ {
+		cpu.Step()
+	}
+
+	// the INC rewrites the LDA's operand so each pass shows the new one
+	var lda []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if i := strings.Index(line, "LDA"); i >= 0 {
+			lda = append(lda, strings.Fields(line[i:])[1])
+		}
+	}
+	expected := []string{"#$00", "#$01", "#$02"}
+	if strings.Join(lda, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %q got %q", expected, lda)
+	}
+
+	// the untouched JMP is still cached
+	if _, ok := cpu.disasmCache[ProgramStart+5]; !ok {
+		t.Error("expected the JMP to be cached")
+	}
+}
+
+func TestCacheDisassemblyStepBack(t *testing.T) {
+	program, err := Assemble(`
+		loop:	LDA #$00
+			INC $DD01
+			JMP loop
+	`, ProgramStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := setup(program.Bytes, nil)
+	cpu.CacheDisassembly = true
+	cpu.EnableHistory(4)
+
+	// caches the LDA before and after the INC changes it
+	for i := 0; i < 4; i++ {
+		cpu.traceDisassembly()
+		cpu.Step()
+	}
+
+	// undoing the INC drops the modified LDA
+	for i := 0; i < 4; i++ {
+		if err := cpu.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect16(t, cpu.pc, newUint16(ProgramStart))
+	if d := cpu.traceDisassembly(); d != "LDA #$00" {
+		t.Errorf("expected LDA #$00 got %q", d)
+	}
+}
+
+func TestAddTraceSink(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa2, 0x02, // LDX #$02
+		0xca,       // loop: DEX
+		0xd0, 0xfd, // BNE loop
+	}, nil)
+
+	var entries []TraceEntry
+	// keep the last two for a post-mortem
+	ring := make([]TraceEntry, 2)
+	n := 0
+
+	cpu.AddTraceSink(func(e TraceEntry) {
+		entries = append(entries, e)
+	})
+	cpu.AddTraceSink(func(e TraceEntry) {
+		ring[n%len(ring)] = e
+		n++
+	})
+
+	for i := 0; i < 5; i++ {
+		cpu.Step()
+	}
+
+	if len(entries) != 5 || n != 5 {
+		t.Fatalf("expected both sinks to get 5 entries got %d and %d", len(entries), n)
+	}
+	expected := TraceEntry{
+		Registers:   Registers{X: 0x02, SP: StackReset, PC: ProgramStart + 2, P: 0x34},
+		Opcode:      0xca,
+		Disassembly: "DEX ",
+		Cycles:      2,
+	}
+	if entries[1] != expected {
+		t.Errorf("expected %+v got %+v", expected, entries[1])
+	}
+	if ring[(n-1)%len(ring)] != entries[4] || ring[(n-2)%len(ring)] != entries[3] {
+		t.Errorf("expected the ring to hold the last entries got %+v", ring)
+	}
+}
+
+// trace a tight loop with and without the disassembly cache
+func BenchmarkTrace(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			cpu := setup([]uint8{
+				0xca,             // loop: DEX
+				0xbd, 0x00, 0x10, // LDA $1000,X
+				0xd0, 0xfa, // BNE loop
+				0x4c, 0x00, 0xdd, // JMP loop
+			}, nil)
+			cpu.Debug = true
+			cpu.CacheDisassembly = cached
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				cpu.Step()
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ const trapDetectorBufferSize = 2
 type trapDetector struct {
 	buffer [trapDetectorBufferSize]uint16
 	index  int
+	// addresses that are never considered traps
+	ignore map[uint16]bool
 }
 
 func (ld *trapDetector) push(value uint16) {
@@ -12,11 +14,29 @@ func (ld *trapDetector) push(value uint16) {
 	ld.index = (ld.index + 1) % trapDetectorBufferSize
 }
 
+// forget the pcs seen so far, addresses to ignore are kept
+func (ld *trapDetector) reset() {
+	ld.buffer = [trapDetectorBufferSize]uint16{}
+	ld.index = 0
+}
+
 func (ld *trapDetector) hastrap() bool {
 	for i := 0; i < trapDetectorBufferSize/2; i++ {
 		if ld.buffer[i] != ld.buffer[i+trapDetectorBufferSize/2] {
 			return false
 		}
+		if ld.ignore[ld.buffer[i]] {
+			return false
+		}
 	}
 	return true
 }
+
+// IgnoreTrap stops the trap detector halting on a loop at address, such as a
+// JMP * used to idle while waiting on an interrupt
+func (cpu *MOS6502) IgnoreTrap(address uint16) {
+	if cpu.trapDetector.ignore == nil {
+		cpu.trapDetector.ignore = make(map[uint16]bool)
+	}
+	cpu.trapDetector.ignore[address] = true
+}
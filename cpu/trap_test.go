@@ -0,0 +1,103 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// run until the cpu halts or n instructions have been stepped
+func runTrap(cpu *MOS6502, n int) {
+	for i := 0; i < n && cpu.Halt() == Continue; i++ {
+		cpu.Step()
+	}
+}
+
+func TestTrapDetector(t *testing.T) {
+	// JMP * never makes progress
+	cpu := setup([]uint8{0x4c, 0x00, 0xdd}, nil)
+	cpu.TrapDetector = true
+
+	runTrap(cpu, 10)
+
+	if cpu.Halt() != HaltTrap {
+		t.Errorf("expected %s got %s", HaltTrap, cpu.Halt())
+	}
+}
+
+func TestTrapDetectorDelayLoop(t *testing.T) {
+	cpu := setup([]uint8{
+		0xa2, 0xff, // LDX #$ff
+		0xca,       // loop: DEX
+		0xd0, 0xfd, // BNE loop
+		0xea, // NOP
+	}, nil)
+	cpu.TrapDetector = true
+
+	// LDX + 255 * (DEX + BNE) + NOP
+	runTrap(cpu, 1+255*2+1)
+
+	if cpu.Halt() != Continue {
+		t.Fatalf("expected delay loop not to be a trap got %s", cpu.Halt())
+	}
+	expect8(t, cpu.x, newUint8(0x00))
+	expect16(t, cpu.pc, newUint16(ProgramStart+6))
+}
+
+func TestTrapDetectorReset(t *testing.T) {
+	// NOP, NOP
+	cpu := setup([]uint8{0xea, 0xea}, nil)
+	cpu.TrapDetector = true
+
+	// the first NOP is the last pc seen before the reset
+	cpu.Step()
+	cpu.ResetCPU()
+
+	// running it again straight after is not a loop
+	cpu.Step()
+	if cpu.Halt() != Continue {
+		t.Fatalf("expected no trap after a reset got %s", cpu.Halt())
+	}
+	expect16(t, cpu.pc, newUint16(ProgramStart+1))
+}
+
+func TestIgnoreTrap(t *testing.T) {
+	// idle loop waiting on an interrupt
+	cpu := setup([]uint8{0x4c, 0x00, 0xdd}, nil)
+	cpu.TrapDetector = true
+	cpu.IgnoreTrap(ProgramStart)
+
+	runTrap(cpu, 10)
+
+	if cpu.Halt() != Continue {
+		t.Errorf("expected ignored trap not to halt got %s", cpu.Halt())
+	}
+}
+
+func TestOnTrap(t *testing.T) {
+	cpu := setup([]uint8{
+		0xe8,             // INX
+		0x4c, 0x01, 0xdd, // JMP *
+	}, nil)
+	cpu.TrapDetector = true
+	cpu.Quiet = true
+
+	var trapped []uint16
+	cpu.OnTrap = func(pc uint16) {
+		// still running so the loop can be disassembled
+		if cpu.Halt() != Continue {
+			t.Errorf("expected OnTrap before the halt got %s", cpu.Halt())
+		}
+		if d := cpu.Disassemble(pc); d.Disassembly != "JMP $DD01" {
+			t.Errorf("expected JMP $DD01 got %q", d.Disassembly)
+		}
+		trapped = append(trapped, pc)
+	}
+
+	runTrap(cpu, 10)
+
+	if cpu.Halt() != HaltTrap {
+		t.Errorf("expected %s got %s", HaltTrap, cpu.Halt())
+	}
+	if len(trapped) != 1 || trapped[0] != 0xdd01 {
+		t.Errorf("expected a single trap at dd01 got %04x", trapped)
+	}
+}
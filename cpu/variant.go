@@ -0,0 +1,36 @@
+package cpu
+
+// Variant selects which member of the 6502 family is emulated
+type Variant uint8
+
+const (
+	// the original NMOS 6502
+	NMOS6502 Variant = iota
+	// the CMOS 65C02
+	CMOS65C02
+	// the WDC 65C02, a 65C02 with WAI and STP
+	WDC65C02
+)
+
+// SetVariant selects the chip to emulate and rebuilds the instruction
+// table. Instructions added with RegisterInstruction need adding again
+func (cpu *MOS6502) SetVariant(variant Variant) {
+	cpu.variant = variant
+	cpu.instructions = [0x100]*instruction{}
+	cpu.setupInstructions()
+	cpu.disasmCache = nil
+}
+
+func (cpu *MOS6502) Variant() Variant {
+	return cpu.variant
+}
+
+// true for any of the CMOS variants
+func (cpu *MOS6502) cmos() bool {
+	return cpu.variant != NMOS6502
+}
+
+// true for the WDC 65C02
+func (cpu *MOS6502) wdc() bool {
+	return cpu.variant == WDC65C02
+}
@@ -1,7 +1,3 @@
 module github.com/jawr/mos6502
 
 go 1.20
-
-require github.com/nsf/termbox-go v1.1.1
-
-require github.com/mattn/go-runewidth v0.0.9 // indirect